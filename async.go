@@ -0,0 +1,159 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy is what an AsyncWriter does when its queue is full
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the entry being written and counts it, logging never blocks
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest queued entry to make room and counts it, logging never blocks
+	DropOldest
+	// Block waits for room in the queue, nothing gets dropped
+	Block
+)
+
+// AsyncOptions for NewAsyncWriter
+type AsyncOptions struct {
+	// QueueSize is the max number of entries waiting to be written, defaults to 1024
+	QueueSize int
+	// Overflow defaults to DropNewest
+	Overflow OverflowPolicy
+}
+
+// ErrWriterClosed is returned when writing to a closed AsyncWriter
+var ErrWriterClosed = errors.New("gcputils: writer closed")
+
+// AsyncWriter writes to another writer from a background goroutine so log calls don't wait on I/O.
+// Use it with Options.Async, or wrap your own writer and pass it to SetOutput. Flush and Close on the Logger only know
+// about the one from Options.Async, so Close your own when you're done.
+type AsyncWriter struct {
+	w        io.Writer
+	overflow OverflowPolicy
+	queue    chan []byte
+	done     chan struct{}
+
+	mu     sync.RWMutex // guards closed so we don't send on a closed queue
+	closed bool
+
+	pending atomic.Int64
+	dropped atomic.Int64
+	errMu   sync.Mutex
+	err     error // first write error
+}
+
+// NewAsyncWriter starts a goroutine writing to w, call Close when you're done to flush it and stop the goroutine.
+func NewAsyncWriter(w io.Writer, o AsyncOptions) *AsyncWriter {
+	if o.QueueSize <= 0 {
+		o.QueueSize = 1024
+	}
+	a := &AsyncWriter{
+		w:        w,
+		overflow: o.Overflow,
+		queue:    make(chan []byte, o.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for b := range a.queue {
+		_, err := a.w.Write(b)
+		if err != nil {
+			a.errMu.Lock()
+			if a.err == nil {
+				a.err = err
+			}
+			a.errMu.Unlock()
+		}
+		a.pending.Add(-1)
+	}
+}
+
+// Write queues a copy of p, it never returns a write error from the underlying writer, see Err.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return 0, ErrWriterClosed
+	}
+	// log.Logger reuses its buffer, so we need a copy
+	b := make([]byte, len(p))
+	copy(b, p)
+	a.pending.Add(1)
+	switch a.overflow {
+	case Block:
+		a.queue <- b
+		return len(p), nil
+	case DropOldest:
+		for {
+			select {
+			case a.queue <- b:
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-a.queue:
+				a.pending.Add(-1)
+				a.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case a.queue <- b:
+		default:
+			a.pending.Add(-1)
+			a.dropped.Add(1)
+		}
+		return len(p), nil
+	}
+}
+
+// Flush waits until everything queued so far has been written, or ctx is done.
+func (a *AsyncWriter) Flush(ctx context.Context) error {
+	t := time.NewTicker(time.Millisecond)
+	defer t.Stop()
+	for a.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// Close writes everything that's queued and stops the background goroutine. Writes after this return ErrWriterClosed.
+func (a *AsyncWriter) Close() error {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return a.Err()
+}
+
+// Dropped returns the number of entries dropped because the queue was full
+func (a *AsyncWriter) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// Err returns the first error from the underlying writer, if any
+func (a *AsyncWriter) Err() error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	return a.err
+}
@@ -0,0 +1,137 @@
+package gcputils_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/treeder/gcputils"
+)
+
+// gateWriter blocks in Write until released, and tells the test when the first write comes in
+type gateWriter struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	got     []string
+}
+
+func (g *gateWriter) Write(p []byte) (int, error) {
+	g.once.Do(func() { close(g.entered) })
+	<-g.release
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.got = append(g.got, string(p))
+	return len(p), nil
+}
+
+func TestAsyncWriterOverflow(t *testing.T) {
+	tests := []struct {
+		policy  gcputils.OverflowPolicy
+		want    string
+		dropped int64
+	}{
+		{gcputils.DropNewest, "1,2,3", 2},
+		{gcputils.DropOldest, "1,4,5", 2},
+		{gcputils.Block, "1,2,3,4,5", 0},
+	}
+	for _, tc := range tests {
+		g := &gateWriter{entered: make(chan struct{}), release: make(chan struct{})}
+		a := gcputils.NewAsyncWriter(g, gcputils.AsyncOptions{QueueSize: 2, Overflow: tc.policy})
+		a.Write([]byte("1"))
+		// 1 is being written, so the queue is empty again
+		<-g.entered
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, s := range []string{"2", "3", "4", "5"} {
+				a.Write([]byte(s))
+			}
+		}()
+		if tc.policy != gcputils.Block {
+			<-done
+		}
+		close(g.release)
+		<-done
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(g.got, ","); got != tc.want {
+			t.Errorf("policy %v: wrote %s, want %s", tc.policy, got, tc.want)
+		}
+		if a.Dropped() != tc.dropped {
+			t.Errorf("policy %v: dropped %d, want %d", tc.policy, a.Dropped(), tc.dropped)
+		}
+		if _, err := a.Write([]byte("late")); !errors.Is(err, gcputils.ErrWriterClosed) {
+			t.Errorf("policy %v: write after Close = %v", tc.policy, err)
+		}
+	}
+}
+
+func TestSetOutputKeepsAsync(t *testing.T) {
+	first := &syncBuffer{}
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   first,
+		Async:    &gcputils.AsyncOptions{},
+	})
+	defer lg.Close()
+	lg.Info().Println("one")
+	g := &gateWriter{entered: make(chan struct{}), release: make(chan struct{})}
+	lg.SetOutput(g)
+	// the first writer was flushed and closed when it was replaced
+	if n := first.lines(); n != 1 {
+		t.Errorf("first output got %d lines, want 1", n)
+	}
+	logged := make(chan struct{})
+	go func() {
+		lg.Info().Println("two")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Println blocked on the new output, it should still be async")
+	}
+	<-g.entered
+	close(g.release)
+	if err := lg.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.got) != 1 || !strings.Contains(g.got[0], `"message":"two`) {
+		t.Errorf("new output got %q", g.got)
+	}
+}
+
+func TestSetOutputSameWriter(t *testing.T) {
+	// a plain buffer, so -race catches the old and new AsyncWriter writing to it at once
+	var out bytes.Buffer
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   &out,
+		Async:    &gcputils.AsyncOptions{Overflow: gcputils.Block},
+	})
+	const n = 2000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			lg.Info().Println(i)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		lg.SetOutput(&out)
+	}
+	<-done
+	if err := lg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkInOrder(t, messages(t, &out), n)
+}
@@ -0,0 +1,171 @@
+package gcputils
+
+import (
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// Options to configure logging explicitly instead of relying on detection, see Configure and New.
+type Options struct {
+	// Platform forces the platform, leave empty to detect it on the first log call
+	Platform Platform
+	// Detector is used to figure out the platform when Platform isn't set. Defaults to DetectPlatform.
+	Detector Detector
+	// ProjectID is required for trace correlation, InitLogging will also set this
+	ProjectID string
+	// Component is written with every entry, same as SetComponent
+	Component string
+	// Fields are added to every entry, fields set on a line win over these
+	Fields map[string]interface{}
+	// Mode forces JSON, console or logging API output, defaults to the GCPUTILS_LOG_MODE env var or else picks based on platform
+	Mode Mode
+	// Output is where structured JSON logs get written. Defaults to os.Stderr.
+	Output io.Writer
+	// Sink replaces the usual outputs, every entry goes to it no matter the mode or platform. See the logtest package.
+	Sink Sink
+	// Async writes to Output from a background goroutine so log calls don't block on I/O.
+	// Close the io.Closer from InitLogging (or the Logger) on shutdown to flush it.
+	Async *AsyncOptions
+	// Service and Version show up in Error Reporting. Default to K_SERVICE and K_REVISION, or the
+	// component/binary name if not set.
+	Service string
+	Version string
+	// ForwardedHops is how many X-Forwarded-For entries, counting from the end, were added by Google's front end and
+	// any proxies you run behind it. The request log's remoteIp is the first of those, anything before it came from the
+	// client and can't be trusted. Defaults to 2, the client address and the load balancer's own.
+	ForwardedHops int
+	// DisableSourceLocation skips looking up the caller for every entry, for when you need every bit of performance
+	DisableSourceLocation bool
+	// Sampling drops repetitive lines, see Sampling. Nil means no sampling.
+	Sampling *Sampling
+	// Redact hides sensitive fields and values in every output, see DefaultRedaction. Nil means no redaction.
+	Redact *Redaction
+	// OnError is called when entries can't be written with the logging API or Sink, defaults to printing them to stderr
+	OnError func(err error)
+	// Debug prints what was detected to stdout, otherwise this package never writes to stdout on its own
+	Debug bool
+}
+
+// Configure sets the platform, mode, project ID and output explicitly for the package level functions, see New
+// for separate Loggers. Optional, call it once at startup before logging anything.
+func Configure(o Options) {
+	std.configure(o)
+}
+
+func (lg *Logger) configure(o Options) {
+	// the old sampler reports what it dropped once we've unlocked, since logging the report may run setup
+	lg.applyOptions(o).close()
+}
+
+// applyOptions replaces the config and outputs, and returns the sampler it replaced
+func (lg *Logger) applyOptions(o Options) *sampler {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if o.ProjectID == "" {
+		// keep the one from InitLogging
+		o.ProjectID = lg.cfg.ProjectID
+	}
+	if o.Fields != nil {
+		// the caller may keep using their map
+		fields := make(map[string]interface{}, len(o.Fields))
+		for k, v := range o.Fields {
+			fields[k] = v
+		}
+		o.Fields = fields
+	}
+	lg.cfg = o
+	lg.setOutput(o.Output, o.Async)
+	if o.Component != "" {
+		lg.component.Store(o.Component)
+	}
+	prevSampler := lg.swapSampling(o.Sampling)
+	lg.SetRedaction(o.Redact)
+	// detect again on next use
+	lg.state.Store(nil)
+	return prevSampler
+}
+
+// resolved is what setup figured out. It's replaced as a whole, never modified, so log calls can use it without locking.
+type resolved struct {
+	platform      Platform
+	mode          Mode
+	sink          Sink
+	serviceCtx    ServiceContext
+	projectID     string
+	fields        map[string]interface{}
+	disableSource bool
+	forwardedHops int
+	onError       func(error)
+}
+
+// setup does the platform detection lazily so merely importing this package doesn't hit the metadata server
+func (lg *Logger) setup() *resolved {
+	if s := lg.state.Load(); s != nil {
+		return s
+	}
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	if s := lg.state.Load(); s != nil {
+		return s
+	}
+	s := &resolved{
+		platform:      lg.cfg.Platform,
+		mode:          lg.cfg.Mode,
+		sink:          lg.cfg.Sink,
+		projectID:     lg.cfg.ProjectID,
+		fields:        lg.cfg.Fields,
+		disableSource: lg.cfg.DisableSourceLocation,
+		forwardedHops: lg.cfg.ForwardedHops,
+		onError:       lg.cfg.OnError,
+	}
+	if s.forwardedHops <= 0 {
+		s.forwardedHops = 2
+	}
+	if s.platform == PlatformUnknown {
+		d := lg.cfg.Detector
+		if d == nil {
+			d = DetectPlatform
+		}
+		s.platform = d()
+	}
+	if s.mode == ModeAuto {
+		s.mode = modeFromEnv()
+	}
+	lg.levels.loadFromEnv(s.platform)
+	s.serviceCtx = lg.defaultServiceContext()
+	if lg.cfg.Debug {
+		lg.printDebug(s)
+	}
+	lg.state.Store(s)
+	return s
+}
+
+// update changes the config and makes the next log call resolve it again
+func (lg *Logger) update(f func(o *Options)) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	f(&lg.cfg)
+	lg.state.Store(nil)
+}
+
+func (lg *Logger) printDebug(r *resolved) {
+	lg.levels.mu.RLock()
+	min := lg.levels.min
+	lg.levels.mu.RUnlock()
+	fmt.Printf("Platform: %v, Mode: %v, Level: %v\n", r.platform, r.mode, min)
+	if !metadata.OnGCE() {
+		return
+	}
+	x, _ := metadata.InstanceAttributes()
+	fmt.Printf("InstanceAttributes: %+v\n", x)
+	s, _ := metadata.InstanceID()
+	fmt.Printf("InstanceID: %+v\n", s)
+	s, _ = metadata.InstanceName()
+	fmt.Printf("InstanceName: %+v\n", s)
+	x, _ = metadata.InstanceTags()
+	fmt.Printf("InstanceTags: %+v\n", x)
+	s, _ = metadata.Zone()
+	fmt.Printf("InstanceZone: %+v\n", s)
+}
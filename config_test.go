@@ -0,0 +1,73 @@
+package gcputils_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestConfigureWhileLogging(t *testing.T) {
+	rec := logtest.NewRecorder()
+	opts := func(i int) gcputils.Options {
+		return gcputils.Options{
+			Platform:              gcputils.PlatformLocal,
+			Sink:                  rec,
+			ProjectID:             "p",
+			Fields:                map[string]interface{}{"i": i},
+			DisableSourceLocation: i%2 == 0,
+			OnError:               func(error) {},
+		}
+	}
+	lg := gcputils.New(opts(0))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			for j := 0; j < 100; j++ {
+				lg.Info().WithTrace(r).Println("hi")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		gcputils.ConfigureLogger(lg, opts(i))
+	}
+	wg.Wait()
+	if rec.Len() != 400 {
+		t.Errorf("got %d entries, want 400", rec.Len())
+	}
+}
+
+func TestConfigureCopiesFields(t *testing.T) {
+	fields := map[string]interface{}{"app": "billing"}
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, Fields: fields})
+	fields["app"] = "changed"
+	fields["extra"] = 1
+	lg.Info().Println("hi")
+	e := rec.Entries()[0]
+	if e.Fields["app"] != "billing" || e.Fields["extra"] != nil {
+		t.Errorf("fields = %v", e.Fields)
+	}
+}
+
+func TestConfigureKeepsProjectID(t *testing.T) {
+	defer gcputils.Configure(gcputils.Options{})
+	gcputils.Configure(gcputils.Options{Platform: gcputils.PlatformLocal})
+	if _, err := gcputils.InitLogging(context.Background(), "p", nil); err != nil {
+		t.Fatal(err)
+	}
+	// configured after InitLogging, without a project ID
+	rec := logtest.Configure(gcputils.Options{Platform: gcputils.PlatformLocal})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	gcputils.Info().WithTrace(r).Println("hi")
+	if e := rec.Entries()[0]; e.Trace != "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace = %q", e.Trace)
+	}
+}
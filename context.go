@@ -0,0 +1,33 @@
+package gcputils
+
+import "context"
+
+// ctxKey is unexported so it can't collide with anyone else's context keys
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx with l attached, get it back with FromContext.
+// A copy of l is stored, so calling F on l afterwards doesn't change what's in the context.
+func NewContext(ctx context.Context, l Line) context.Context {
+	if l2, ok := l.(*line); ok {
+		l = l2.clone()
+	}
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns a copy of the Line attached with NewContext or Middleware, or a new INFO Line if there isn't one.
+// It's a copy so F only affects that one log call, use LWith to add fields for the rest of the request.
+func FromContext(ctx context.Context) Line {
+	if l, ok := ctx.Value(ctxKey{}).(Line); ok {
+		if l2, ok := l.(*line); ok {
+			return l2.clone()
+		}
+		return l
+	}
+	return Info()
+}
+
+// LWith adds a field to the Line in ctx and returns a derived context with it, the original is left as is
+func LWith(ctx context.Context, key string, value interface{}) context.Context {
+	// FromContext already made a copy that nothing else has
+	return context.WithValue(ctx, ctxKey{}, FromContext(ctx).F(key, value))
+}
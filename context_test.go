@@ -0,0 +1,64 @@
+package gcputils_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestFromContextIsolatesFields(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		gcputils.FromContext(ctx).F("secret_thing", 1).Println("inner")
+		gcputils.FromContext(ctx).Println("inner2")
+		ctx = gcputils.LWith(ctx, "user", "bob")
+		gcputils.FromContext(ctx).Println("inner3")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	tests := []struct {
+		msg    string
+		secret bool
+		user   bool
+	}{
+		{msg: "inner", secret: true},
+		{msg: "inner2"},
+		{msg: "inner3", user: true},
+		{msg: "GET / 200"},
+	}
+	for _, tc := range tests {
+		es := rec.Find(func(e gcputils.Entry) bool { return e.Message == tc.msg || e.Message == tc.msg+"\n" })
+		if len(es) != 1 {
+			t.Fatalf("%q: got %d entries, want 1", tc.msg, len(es))
+		}
+		if _, ok := es[0].Fields["secret_thing"]; ok != tc.secret {
+			t.Errorf("%q: secret_thing set = %v, want %v", tc.msg, ok, tc.secret)
+		}
+		if _, ok := es[0].Fields["user"]; ok != tc.user {
+			t.Errorf("%q: user set = %v, want %v", tc.msg, ok, tc.user)
+		}
+	}
+}
+
+func TestFromContextConcurrentF(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	ctx := gcputils.NewContext(context.Background(), lg.Info())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			gcputils.FromContext(ctx).F("i", i).Println("concurrent")
+		}(i)
+	}
+	wg.Wait()
+	if rec.Len() != 10 {
+		t.Errorf("got %d entries, want 10", rec.Len())
+	}
+}
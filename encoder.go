@@ -0,0 +1,650 @@
+package gcputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	bufPool = sync.Pool{New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	}}
+	keysPool = sync.Pool{New: func() interface{} {
+		k := make([]string, 0, 16)
+		return &k
+	}}
+)
+
+// written tracks which of the keys the encoder writes itself are in the current entry
+type written uint16
+
+const (
+	wSeverity written = 1 << iota
+	wMessage
+	wComponent
+	wTrace
+	wSpanID
+	wTraceSampled
+	wSourceLocation
+	wLabels
+	wOperation
+	wHTTPRequest
+	wType
+	wServiceContext
+	wContext
+	wMarshalError
+)
+
+// keys that the encoder can write itself. A field with one of these names is written as "fields.<name>" when the
+// entry has that key too, so we never write a key twice or lose the field.
+var reservedKeys = map[string]written{
+	"severity":                              wSeverity,
+	"message":                               wMessage,
+	"component":                             wComponent,
+	"logging.googleapis.com/trace":          wTrace,
+	"logging.googleapis.com/spanId":         wSpanID,
+	"logging.googleapis.com/trace_sampled":  wTraceSampled,
+	"logging.googleapis.com/sourceLocation": wSourceLocation,
+	"logging.googleapis.com/labels":         wLabels,
+	"logging.googleapis.com/operation":      wOperation,
+	"httpRequest":                           wHTTPRequest,
+	"@type":                                 wType,
+	"serviceContext":                        wServiceContext,
+	"context":                               wContext,
+	"_marshal_error":                        wMarshalError,
+}
+
+// setOutput writes to w from now on, through a new AsyncWriter if async is set. The AsyncWriter it replaces is closed,
+// with what it dropped kept for the next Flush. Call with lg.mu held.
+func (lg *Logger) setOutput(w io.Writer, async *AsyncOptions) {
+	if w == nil {
+		w = os.Stderr
+	}
+	lg.outMu.Lock()
+	prev := lg.async
+	if prev != nil {
+		// write out its queue before new lines can reach the writer, it's often the same one
+		prev.Close()
+	}
+	lg.async = nil
+	if async != nil {
+		lg.async = NewAsyncWriter(w, *async)
+		w = lg.async
+	}
+	lg.out = w
+	lg.outMu.Unlock()
+	if prev != nil {
+		lg.flush.retire(prev)
+	}
+}
+
+// writeEntry encodes e into a pooled buffer and writes it as one line to the output
+func (lg *Logger) writeEntry(e *Entry) {
+	bp := bufPool.Get().(*[]byte)
+	b := e.appendJSON((*bp)[:0])
+	b = append(b, '\n')
+	lg.outMu.Lock()
+	lg.out.Write(b)
+	lg.outMu.Unlock()
+	// don't keep huge buffers around
+	if cap(b) <= 64<<10 {
+		*bp = b
+		bufPool.Put(bp)
+	}
+}
+
+// appendJSON writes the entry in a stable order: severity, message, trace, component, the other special fields,
+// then the rest of the fields sorted by key.
+func (e *Entry) appendJSON(b []byte) []byte {
+	sev := e.Severity
+	if sev == "" {
+		sev = "INFO"
+	}
+	// _marshal_error is only known at the end, so always treat it as written
+	w := wSeverity | wMessage | wMarshalError
+	b = append(b, `{"severity":`...)
+	b = appendString(b, sev)
+	b = append(b, `,"message":`...)
+	b = appendString(b, e.Message)
+	if e.Trace != "" {
+		b = append(b, `,"logging.googleapis.com/trace":`...)
+		b = appendString(b, e.Trace)
+		w |= wTrace | wTraceSampled
+		if e.SpanID != "" {
+			b = append(b, `,"logging.googleapis.com/spanId":`...)
+			b = appendString(b, e.SpanID)
+			w |= wSpanID
+		}
+		b = append(b, `,"logging.googleapis.com/trace_sampled":`...)
+		b = strconv.AppendBool(b, e.TraceSampled)
+	}
+	// a component field wins over the global one
+	var errs map[string]string
+	if c, ok := e.Fields["component"]; ok {
+		b = append(b, `,"component":`...)
+		var err error
+		b, err = appendSafe(b, c, 0)
+		if err != nil {
+			errs = map[string]string{"component": err.Error()}
+		}
+		w |= wComponent
+	} else if e.Component != "" {
+		b = append(b, `,"component":`...)
+		b = appendString(b, e.Component)
+	}
+	if e.SourceLocation != nil {
+		b = append(b, `,"logging.googleapis.com/sourceLocation":`...)
+		b = e.SourceLocation.appendJSON(b)
+		w |= wSourceLocation
+	}
+	if len(e.Labels) > 0 {
+		b = append(b, `,"logging.googleapis.com/labels":`...)
+		b = appendLabels(b, e.Labels)
+		w |= wLabels
+	}
+	if e.Operation != nil {
+		b = append(b, `,"logging.googleapis.com/operation":`...)
+		b = e.Operation.appendJSON(b)
+		w |= wOperation
+	}
+	if e.HTTPRequest != nil {
+		b = append(b, `,"httpRequest":`...)
+		b = e.HTTPRequest.appendJSON(b)
+		w |= wHTTPRequest
+	}
+	b, w = appendErrorEvent(b, w, e.Type, e.ServiceContext, e.Context)
+	if len(e.Fields) > 0 {
+		var ferrs map[string]string
+		b, ferrs = appendFields(b, e.Fields, w, 0)
+		errs = mergeErrs(errs, ferrs)
+	}
+	b = appendMarshalErrors(b, errs)
+	return append(b, '}')
+}
+
+// appendPayload writes the jsonPayload for the logging API: the message, the ReportedErrorEvent bits and the fields.
+// Going through our encoder means a field the API client can't marshal doesn't cost us the whole entry.
+func appendPayload(b []byte, msg string, fields map[string]interface{}, errType string, svcCtx *ServiceContext, errCtx *ErrorContext) []byte {
+	// severity, trace etc. are set on the entry itself so they're fine as payload fields
+	w := wMessage | wMarshalError
+	b = append(b, `{"message":`...)
+	b = appendString(b, msg)
+	var errs map[string]string
+	if c, ok := fields["component"]; ok {
+		b = append(b, `,"component":`...)
+		var err error
+		b, err = appendSafe(b, c, 0)
+		if err != nil {
+			errs = map[string]string{"component": err.Error()}
+		}
+		w |= wComponent
+	}
+	b, w = appendErrorEvent(b, w, errType, svcCtx, errCtx)
+	if len(fields) > 0 {
+		var ferrs map[string]string
+		b, ferrs = appendFields(b, fields, w, 0)
+		errs = mergeErrs(errs, ferrs)
+	}
+	b = appendMarshalErrors(b, errs)
+	return append(b, '}')
+}
+
+// appendErrorEvent writes the ReportedErrorEvent keys, if errType is set
+func appendErrorEvent(b []byte, w written, errType string, svcCtx *ServiceContext, errCtx *ErrorContext) ([]byte, written) {
+	if errType == "" {
+		return b, w
+	}
+	b = append(b, `,"@type":`...)
+	b = appendString(b, errType)
+	w |= wType
+	if svcCtx != nil {
+		b = append(b, `,"serviceContext":`...)
+		b = svcCtx.appendJSON(b)
+		w |= wServiceContext
+	}
+	if errCtx != nil && errCtx.ReportLocation != nil {
+		b = append(b, `,"context":`...)
+		b = errCtx.appendJSON(b)
+		w |= wContext
+	}
+	return b, w
+}
+
+func mergeErrs(a, b map[string]string) map[string]string {
+	if a == nil {
+		return b
+	}
+	for k, v := range b {
+		a[k] = v
+	}
+	return a
+}
+
+// maxDepth stops runaway recursion on nested fields, like a map that contains itself
+const maxDepth = 32
+
+// appendFields writes sorted key/values, without the surrounding braces. Fields named like a key in w get a
+// "fields." prefix, except component which was already written as the component. Values that couldn't be
+// encoded are still written with %+v and returned in errs, keyed by field name.
+func appendFields(b []byte, fields map[string]interface{}, w written, depth int) (_ []byte, errs map[string]string) {
+	kp := keysPool.Get().(*[]string)
+	keys := (*kp)[:0]
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := fields[k]
+		if w != 0 && w&reservedKeys[k] != 0 {
+			if k == "component" {
+				continue
+			}
+			// keep going until it doesn't clash with another field either
+			k = "fields." + k
+			for _, ok := fields[k]; ok; _, ok = fields[k] {
+				k = "fields." + k
+			}
+		}
+		b = append(b, ',')
+		b = appendString(b, k)
+		b = append(b, ':')
+		var err error
+		b, err = appendSafe(b, v, depth)
+		if err != nil {
+			if errs == nil {
+				errs = map[string]string{}
+			}
+			errs[k] = err.Error()
+		}
+		// errors get their wrapped chain alongside, unless that would clobber a field
+		if e, ok := v.(error); ok && err == nil {
+			ck := k + "_chain"
+			if _, exists := fields[ck]; !exists {
+				b = appendErrorChain(b, ck, e)
+			}
+		}
+	}
+	clear(keys)
+	*kp = keys
+	keysPool.Put(kp)
+	return b, errs
+}
+
+// appendMarshalErrors flags the fields that had to fall back to %+v
+func appendMarshalErrors(b []byte, errs map[string]string) []byte {
+	if len(errs) == 0 {
+		return b
+	}
+	b = append(b, `,"_marshal_error":`...)
+	return appendLabels(b, errs)
+}
+
+// appendSafe is appendValue that can't panic, a bad MarshalJSON or String method shouldn't cost us the entry
+func appendSafe(b []byte, v interface{}, depth int) (out []byte, err error) {
+	n := len(b)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic encoding %T: %v", v, r)
+			out = appendString(b[:n], fallback(v, err))
+		}
+	}()
+	return appendValue(b, v, depth)
+}
+
+// appendValue writes v as JSON. If v can't be encoded it writes a string version of it instead and returns why.
+func appendValue(b []byte, v interface{}, depth int) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendString(b, x), nil
+	case bool:
+		return strconv.AppendBool(b, x), nil
+	case int:
+		return strconv.AppendInt(b, int64(x), 10), nil
+	case int8:
+		return strconv.AppendInt(b, int64(x), 10), nil
+	case int16:
+		return strconv.AppendInt(b, int64(x), 10), nil
+	case int32:
+		return strconv.AppendInt(b, int64(x), 10), nil
+	case int64:
+		return strconv.AppendInt(b, x, 10), nil
+	case uint:
+		return strconv.AppendUint(b, uint64(x), 10), nil
+	case uint8:
+		return strconv.AppendUint(b, uint64(x), 10), nil
+	case uint16:
+		return strconv.AppendUint(b, uint64(x), 10), nil
+	case uint32:
+		return strconv.AppendUint(b, uint64(x), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, x, 10), nil
+	case float32:
+		return appendFloat(b, float64(x), 32), nil
+	case float64:
+		return appendFloat(b, x, 64), nil
+	case json.Number:
+		// what redaction decodes numbers into
+		if x == "" || (x[0] != '-' && (x[0] < '0' || x[0] > '9')) || !json.Valid([]byte(x)) {
+			return appendString(b, string(x)), nil
+		}
+		return append(b, x...), nil
+	case time.Time:
+		b = append(b, '"')
+		b = x.AppendFormat(b, time.RFC3339Nano)
+		return append(b, '"'), nil
+	case time.Duration:
+		return appendString(b, x.String()), nil
+	case map[string]interface{}:
+		// nested fields, like slog groups
+		if depth >= maxDepth {
+			err := errors.New("too deeply nested, possibly a cycle")
+			return appendString(b, fallback(x, err)), err
+		}
+		b = append(b, '{')
+		n := len(b)
+		b, errs := appendFields(b, x, 0, depth+1)
+		if len(b) > n {
+			// drop the leading comma
+			b = append(b[:n], b[n+1:]...)
+		}
+		b = append(b, '}')
+		if len(errs) > 0 {
+			return b, nestedError(errs)
+		}
+		return b, nil
+	case error:
+		// before json.Marshaler, we want what the error says, the chain gets written by appendFields
+		return appendString(b, x.Error()), nil
+	case json.Marshaler:
+		return appendMarshaler(b, x)
+	case fmt.Stringer:
+		return appendString(b, x.String()), nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return appendString(b, fallback(v, err)), err
+	}
+	return append(b, j...), nil
+}
+
+func appendMarshaler(b []byte, m json.Marshaler) ([]byte, error) {
+	j, err := m.MarshalJSON()
+	if err != nil {
+		return appendString(b, fallback(m, err)), err
+	}
+	// compact also validates it, so a bad MarshalJSON can't break the whole line
+	buf := bytes.NewBuffer(b)
+	err = json.Compact(buf, j)
+	if err != nil {
+		return appendString(b, fallback(m, err)), err
+	}
+	return buf.Bytes(), nil
+}
+
+// fallback is the string we write when v can't be encoded as JSON
+func fallback(v interface{}, err error) string {
+	var uv *json.UnsupportedValueError
+	if errors.As(err, &uv) && strings.HasPrefix(uv.Str, "encountered a cycle") || strings.HasSuffix(err.Error(), "possibly a cycle") {
+		// %+v would recurse forever
+		return fmt.Sprintf("%T", v)
+	}
+	// fmt recovers from panicking String and Error methods itself
+	return fmt.Sprintf("%+v", v)
+}
+
+// nestedError sums up the errors from a nested map into one
+func nestedError(errs map[string]string) error {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var s strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			s.WriteString("; ")
+		}
+		s.WriteString(k)
+		s.WriteString(": ")
+		s.WriteString(errs[k])
+	}
+	return errors.New(s.String())
+}
+
+// maxChain caps how much of an error chain we write
+const maxChain = 16
+
+// appendErrorChain writes key: [{type, message}, ...] for everything err wraps, nothing if it doesn't wrap anything
+func appendErrorChain(b []byte, key string, err error) []byte {
+	chain := unwrapAll(err)
+	if len(chain) == 0 {
+		return b
+	}
+	b = append(b, ',')
+	b = appendString(b, key)
+	b = append(b, `:[`...)
+	for i, e := range chain {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"type":`...)
+		b = appendString(b, fmt.Sprintf("%T", e))
+		b = append(b, `,"message":`...)
+		b = appendString(b, safeError(e))
+		b = append(b, '}')
+	}
+	return append(b, ']')
+}
+
+// unwrapAll walks everything err wraps, depth first, including errors.Join style multi errors
+func unwrapAll(err error) []error {
+	var chain []error
+	var walk func(error)
+	walk = func(e error) {
+		var next []error
+		switch x := e.(type) {
+		case interface{ Unwrap() error }:
+			if u := x.Unwrap(); u != nil {
+				next = []error{u}
+			}
+		case interface{ Unwrap() []error }:
+			next = x.Unwrap()
+		}
+		for _, u := range next {
+			if u == nil || len(chain) >= maxChain {
+				continue
+			}
+			chain = append(chain, u)
+			walk(u)
+		}
+	}
+	walk(err)
+	return chain
+}
+
+// safeError is err.Error() that survives a panicking Error method
+func safeError(err error) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			s = fmt.Sprintf("%T (Error panicked: %v)", err, r)
+		}
+	}()
+	return err.Error()
+}
+
+func appendFloat(b []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// not valid JSON numbers
+		return appendString(b, strconv.FormatFloat(f, 'g', -1, bits))
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bits)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString writes s as a JSON string, escaping like encoding/json minus the HTML escaping
+func appendString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 break JavaScript, encoding/json escapes them too
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+func appendLabels(b []byte, labels map[string]string) []byte {
+	kp := keysPool.Get().(*[]string)
+	keys := (*kp)[:0]
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, k)
+		b = append(b, ':')
+		b = appendString(b, labels[k])
+	}
+	clear(keys)
+	*kp = keys
+	keysPool.Put(kp)
+	return append(b, '}')
+}
+
+func (s *SourceLocation) appendJSON(b []byte) []byte {
+	b = append(b, `{"file":`...)
+	b = appendString(b, s.File)
+	b = append(b, `,"line":"`...)
+	b = strconv.AppendInt(b, s.Line, 10)
+	b = append(b, `","function":`...)
+	b = appendString(b, s.Function)
+	return append(b, '}')
+}
+
+func (o *Operation) appendJSON(b []byte) []byte {
+	b = append(b, `{"id":`...)
+	b = appendString(b, o.ID)
+	b = append(b, `,"producer":`...)
+	b = appendString(b, o.Producer)
+	if o.First {
+		b = append(b, `,"first":true`...)
+	}
+	if o.Last {
+		b = append(b, `,"last":true`...)
+	}
+	return append(b, '}')
+}
+
+func (r *HTTPRequest) appendJSON(b []byte) []byte {
+	b = append(b, `{"requestMethod":`...)
+	b = appendString(b, r.RequestMethod)
+	b = append(b, `,"requestUrl":`...)
+	b = appendString(b, r.RequestURL)
+	if r.RequestSize > 0 {
+		b = append(b, `,"requestSize":"`...)
+		b = strconv.AppendInt(b, r.RequestSize, 10)
+		b = append(b, '"')
+	}
+	if r.Status != 0 {
+		b = append(b, `,"status":`...)
+		b = strconv.AppendInt(b, int64(r.Status), 10)
+	}
+	if r.ResponseSize > 0 {
+		b = append(b, `,"responseSize":"`...)
+		b = strconv.AppendInt(b, r.ResponseSize, 10)
+		b = append(b, '"')
+	}
+	for _, kv := range [...]struct{ k, v string }{
+		{"userAgent", r.UserAgent},
+		{"remoteIp", r.RemoteIP},
+		{"referer", r.Referer},
+		{"latency", r.Latency},
+		{"protocol", r.Protocol},
+	} {
+		if kv.v != "" {
+			b = append(b, ',')
+			b = appendString(b, kv.k)
+			b = append(b, ':')
+			b = appendString(b, kv.v)
+		}
+	}
+	return append(b, '}')
+}
+
+func (s *ServiceContext) appendJSON(b []byte) []byte {
+	b = append(b, `{"service":`...)
+	b = appendString(b, s.Service)
+	if s.Version != "" {
+		b = append(b, `,"version":`...)
+		b = appendString(b, s.Version)
+	}
+	return append(b, '}')
+}
+
+func (c *ErrorContext) appendJSON(b []byte) []byte {
+	l := c.ReportLocation
+	b = append(b, `{"reportLocation":{"filePath":`...)
+	b = appendString(b, l.FilePath)
+	b = append(b, `,"lineNumber":`...)
+	b = strconv.AppendInt(b, l.LineNumber, 10)
+	b = append(b, `,"functionName":`...)
+	b = appendString(b, l.FunctionName)
+	return append(b, "}}"...)
+}
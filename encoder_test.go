@@ -0,0 +1,282 @@
+package gcputils_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/treeder/gcputils"
+)
+
+func TestAppendString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", `"plain"`},
+		{`q"b\`, `"q\"b\\"`},
+		{"a\nb\r\tc", `"a\nb\r\tc"`},
+		{"\x00\x1f", `"\u0000\u001f"`},
+		{"héllo 世界", `"héllo 世界"`},
+		{"bad\xffutf8", `"bad\ufffdutf8"`},
+		{"js\u2028\u2029", `"js\u2028\u2029"`},
+		{"<a&b>", `"<a&b>"`},
+	}
+	for _, tc := range tests {
+		got := string(gcputils.AppendString(nil, tc.in))
+		if got != tc.want {
+			t.Errorf("appendString(%q) = %s, want %s", tc.in, got, tc.want)
+		}
+		var s string
+		if err := json.Unmarshal([]byte(got), &s); err != nil {
+			t.Errorf("appendString(%q) isn't valid JSON: %v", tc.in, err)
+		}
+	}
+}
+
+type cyclic struct {
+	Next *cyclic
+}
+
+func TestFallback(t *testing.T) {
+	c := &cyclic{}
+	c.Next = c
+	_, cycleErr := json.Marshal(c)
+	tests := []struct {
+		name string
+		v    interface{}
+		err  error
+		want string
+	}{
+		{"value", struct{ A int }{1}, errors.New("nope"), "{A:1}"},
+		{"json cycle", c, cycleErr, "*gcputils_test.cyclic"},
+		{"nested cycle", map[string]interface{}{}, errors.New("too deeply nested, possibly a cycle"), "map[string]interface {}"},
+	}
+	for _, tc := range tests {
+		if got := gcputils.Fallback(tc.v, tc.err); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestEntryReservedFields(t *testing.T) {
+	errEvent := gcputils.Entry{
+		Type:           "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent",
+		ServiceContext: &gcputils.ServiceContext{Service: "api"},
+		Context:        &gcputils.ErrorContext{ReportLocation: &gcputils.ReportLocation{FilePath: "a.go", LineNumber: 1}},
+	}
+	tests := []struct {
+		name    string
+		entry   gcputils.Entry
+		fields  map[string]interface{}
+		want    map[string]interface{}
+		missing []string
+	}{
+		{
+			name:   "kept when not written",
+			fields: map[string]interface{}{"context": "ctx", "serviceContext": "svc", "@type": "t", "httpRequest": "req"},
+			want:   map[string]interface{}{"context": "ctx", "serviceContext": "svc", "@type": "t", "httpRequest": "req"},
+		},
+		{
+			name:    "renamed when written",
+			entry:   errEvent,
+			fields:  map[string]interface{}{"context": "ctx", "serviceContext": "svc"},
+			want:    map[string]interface{}{"fields.context": "ctx", "fields.serviceContext": "svc"},
+			missing: []string{},
+		},
+		{
+			name:   "always written",
+			fields: map[string]interface{}{"severity": "x", "message": "y", "_marshal_error": "z"},
+			want:   map[string]interface{}{"severity": "INFO", "message": "m", "fields.severity": "x", "fields.message": "y", "fields._marshal_error": "z"},
+		},
+		{
+			name:   "renamed past other fields",
+			entry:  errEvent,
+			fields: map[string]interface{}{"context": "ctx", "fields.context": "mine", "fields.fields.context": "also mine"},
+			want:   map[string]interface{}{"fields.context": "mine", "fields.fields.context": "also mine", "fields.fields.fields.context": "ctx"},
+		},
+		{
+			name:    "component field is the component",
+			entry:   gcputils.Entry{Component: "global"},
+			fields:  map[string]interface{}{"component": "db"},
+			want:    map[string]interface{}{"component": "db"},
+			missing: []string{"fields.component"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := tc.entry
+			e.Message = "m"
+			e.Fields = tc.fields
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(e.String()), &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", e.String(), err)
+			}
+			if n := strings.Count(e.String(), `":`); n != len(got)+nested(got) {
+				t.Errorf("duplicate keys in %s", e.String())
+			}
+			for k, v := range tc.want {
+				if !reflect.DeepEqual(got[k], v) {
+					t.Errorf("%s = %v, want %v in %s", k, got[k], v, e.String())
+				}
+			}
+			for _, k := range tc.missing {
+				if _, ok := got[k]; ok {
+					t.Errorf("didn't expect %s in %s", k, e.String())
+				}
+			}
+		})
+	}
+}
+
+// badMarshaler fails to encode itself
+type badMarshaler struct{}
+
+func (badMarshaler) MarshalJSON() ([]byte, error) { return nil, errors.New("no json") }
+
+func TestEntryFieldValues(t *testing.T) {
+	c := &cyclic{}
+	c.Next = c
+	self := map[string]interface{}{}
+	self["self"] = self
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{
+			name:  "wrapped error chain",
+			value: fmt.Errorf("outer: %w", fmt.Errorf("mid: %w", errors.New("base"))),
+			want: `"v":"outer: mid: base","v_chain":[{"type":"*fmt.wrapError","message":"mid: base"},` +
+				`{"type":"*errors.errorString","message":"base"}]`,
+		},
+		{
+			name:  "plain error has no chain",
+			value: errors.New("base"),
+			want:  `"v":"base"`,
+		},
+		{
+			name:  "json.Marshaler error",
+			value: badMarshaler{},
+			want:  `"v":"{}","_marshal_error":{"v":"no json"}`,
+		},
+		{
+			name:  "json.Number",
+			value: json.Number("12.5"),
+			want:  `"v":12.5`,
+		},
+		{
+			name:  "invalid json.Number",
+			value: json.Number("12 }"),
+			want:  `"v":"12 }"`,
+		},
+		{
+			name:  "json cycle",
+			value: c,
+			want:  `"v":"*gcputils_test.cyclic","_marshal_error":{"v":"json: unsupported value: encountered a cycle via *gcputils_test.cyclic"}`,
+		},
+		{
+			name:  "map cycle",
+			value: self,
+			want: `"v":` + strings.Repeat(`{"self":`, 32) + `"map[string]interface {}"` + strings.Repeat("}", 32) +
+				`,"_marshal_error":{"v":"` + strings.Repeat("self: ", 32) + `too deeply nested, possibly a cycle"}`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := gcputils.Entry{Message: "m", Fields: map[string]interface{}{"v": tc.value}}
+			want := `{"severity":"INFO","message":"m",` + tc.want + "}"
+			if got := e.String(); got != want {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// nested counts the keys in nested objects, for checking there are no duplicates
+func nested(m map[string]interface{}) int {
+	n := 0
+	for _, v := range m {
+		if m2, ok := v.(map[string]interface{}); ok {
+			n += len(m2) + nested(m2)
+		}
+	}
+	return n
+}
+
+// oldEntryString is how entries were encoded before the pooled encoder, kept to compare against
+func oldEntryString(e gcputils.Entry) string {
+	if e.Severity == "" {
+		e.Severity = "INFO"
+	}
+	m := map[string]interface{}{}
+	m["message"] = e.Message
+	m["severity"] = e.Severity
+	if e.Trace != "" {
+		m["logging.googleapis.com/trace"] = e.Trace
+		if e.SpanID != "" {
+			m["logging.googleapis.com/spanId"] = e.SpanID
+		}
+		m["logging.googleapis.com/trace_sampled"] = e.TraceSampled
+	}
+	if e.Component != "" {
+		m["component"] = e.Component
+	}
+	if e.HTTPRequest != nil {
+		m["httpRequest"] = e.HTTPRequest
+	}
+	if e.SourceLocation != nil {
+		m["logging.googleapis.com/sourceLocation"] = e.SourceLocation
+	}
+	if len(e.Labels) > 0 {
+		m["logging.googleapis.com/labels"] = e.Labels
+	}
+	for k, v := range e.Fields {
+		m[k] = v
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		log.Printf("json.Marshal: %v", err)
+	}
+	return string(out)
+}
+
+func benchEntry() gcputils.Entry {
+	return gcputils.Entry{
+		Severity:       "INFO",
+		Message:        "handled request",
+		Trace:          "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:         "00f067aa0ba902b7",
+		TraceSampled:   true,
+		Component:      "api",
+		SourceLocation: &gcputils.SourceLocation{File: "main.go", Line: 42, Function: "main.handle"},
+		Labels:         map[string]string{"env": "prod"},
+		Fields: map[string]interface{}{
+			"user":     "bob",
+			"attempt":  3,
+			"duration": 1.5,
+			"ok":       true,
+			"tags":     []string{"a", "b"},
+			"err":      fmt.Errorf("wrapped: %w", errors.New("boom")),
+		},
+	}
+}
+
+func BenchmarkEntryString(b *testing.B) {
+	e := benchEntry()
+	b.Run("flatten+json.Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = oldEntryString(e)
+		}
+	})
+	b.Run("appendJSON", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = e.String()
+		}
+	})
+}
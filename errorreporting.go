@@ -0,0 +1,73 @@
+package gcputils
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// reportedErrorEventType makes Error Reporting pick up an entry on platforms where it doesn't automatically,
+// see https://cloud.google.com/error-reporting/docs/formatting-error-messages
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// ServiceContext identifies your service in Error Reporting
+type ServiceContext struct {
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+}
+
+// ErrorContext is the context of a ReportedErrorEvent
+type ErrorContext struct {
+	ReportLocation *ReportLocation `json:"reportLocation,omitempty"`
+}
+
+// ReportLocation is where the error was reported from, Error Reporting uses it if there's no stack trace
+type ReportLocation struct {
+	FilePath     string `json:"filePath"`
+	LineNumber   int64  `json:"lineNumber"`
+	FunctionName string `json:"functionName"`
+}
+
+// needsErrorEvent returns true if Error Reporting won't pick up errors on its own.
+// Cloud Run does as long as the stack is in the message.
+func (p Platform) needsErrorEvent() bool {
+	return p != PlatformCloudRun && p != PlatformCloudRunJob
+}
+
+// defaultServiceContext uses Options.Service/Version, then the env vars that Google sets, then the component or binary name
+func (lg *Logger) defaultServiceContext() ServiceContext {
+	sc := ServiceContext{Service: lg.cfg.Service, Version: lg.cfg.Version}
+	if sc.Service == "" {
+		sc.Service = firstEnv("K_SERVICE", "GAE_SERVICE", "CLOUD_RUN_JOB")
+	}
+	if sc.Service == "" {
+		sc.Service = lg.componentName()
+	}
+	if sc.Service == "" {
+		sc.Service = filepath.Base(os.Args[0])
+	}
+	if sc.Version == "" {
+		sc.Version = firstEnv("K_REVISION", "GAE_VERSION")
+	}
+	return sc
+}
+
+func firstEnv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// errorContext uses the source location as the report location
+func (l *line) errorContext() *ErrorContext {
+	if l.source == nil {
+		return nil
+	}
+	return &ErrorContext{ReportLocation: &ReportLocation{
+		FilePath:     l.source.File,
+		LineNumber:   l.source.Line,
+		FunctionName: l.source.Function,
+	}}
+}
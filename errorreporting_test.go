@@ -0,0 +1,97 @@
+package gcputils_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/treeder/gcputils"
+)
+
+const reportedErrorEvent = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// TestErrorReporting runs a handler that logs an error behind a real server and checks what ends up in the output
+func TestErrorReporting(t *testing.T) {
+	tests := []struct {
+		platform gcputils.Platform
+		event    bool
+	}{
+		{gcputils.PlatformGKE, true},
+		{gcputils.PlatformGCE, true},
+		{gcputils.PlatformAppEngine, true},
+		{gcputils.PlatformCloudRun, false},
+		{gcputils.PlatformCloudRunJob, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.platform.String(), func(t *testing.T) {
+			out := &syncBuffer{}
+			lg := gcputils.New(gcputils.Options{
+				Platform: tc.platform,
+				Mode:     gcputils.ModeJSON,
+				Output:   out,
+				Service:  "billing",
+				Version:  "v3",
+			})
+			srv := httptest.NewServer(lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gcputils.FromContext(r.Context()).F("invoice", 7).Error().Println(errors.New("charge failed"))
+				w.WriteHeader(http.StatusBadGateway)
+			})))
+			resp, err := http.Get(srv.URL + "/charge")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			srv.Close()
+
+			var errEntry, reqEntry map[string]interface{}
+			sc := bufio.NewScanner(strings.NewReader(out.b.String()))
+			for sc.Scan() {
+				var m map[string]interface{}
+				if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+					t.Fatalf("invalid JSON %s: %v", sc.Text(), err)
+				}
+				sev, _ := m["severity"].(string)
+				switch strings.ToUpper(sev) {
+				case "ERROR":
+					errEntry = m
+				case "WARNING":
+					reqEntry = m
+				}
+			}
+			if errEntry == nil || reqEntry == nil {
+				t.Fatalf("missing entries in %s", out.b.String())
+			}
+			if _, ok := reqEntry["@type"]; ok {
+				t.Errorf("the request log isn't an error: %v", reqEntry)
+			}
+			if !strings.Contains(errEntry["message"].(string), "charge failed") {
+				t.Errorf("message = %q", errEntry["message"])
+			}
+			if errEntry["invoice"] != float64(7) {
+				t.Errorf("invoice = %v", errEntry["invoice"])
+			}
+			if !tc.event {
+				if _, ok := errEntry["@type"]; ok {
+					t.Errorf("%v picks up errors itself, didn't expect @type: %v", tc.platform, errEntry)
+				}
+				return
+			}
+			if errEntry["@type"] != reportedErrorEvent {
+				t.Errorf("@type = %v", errEntry["@type"])
+			}
+			svc, _ := errEntry["serviceContext"].(map[string]interface{})
+			if svc["service"] != "billing" || svc["version"] != "v3" {
+				t.Errorf("serviceContext = %v", errEntry["serviceContext"])
+			}
+			ctx, _ := errEntry["context"].(map[string]interface{})
+			loc, _ := ctx["reportLocation"].(map[string]interface{})
+			if file, _ := loc["filePath"].(string); !strings.HasSuffix(file, "errorreporting_test.go") {
+				t.Errorf("reportLocation = %v", ctx)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package gcputils
+
+import (
+	"os"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// SamplerAllow exposes the sampler's window math to the tests
+func SamplerAllow(cfg Sampling) func(key string) bool {
+	s := &sampler{cfg: cfg, counts: map[string]int{}, dropped: map[string]int64{}}
+	return s.allow
+}
+
+// MaxSampleKeys is the cap on keys the sampler tracks
+const MaxSampleKeys = maxSampleKeys
+
+// AppendString and Fallback expose the encoder's helpers
+var (
+	AppendString = appendString
+	Fallback     = fallback
+)
+
+// ParseTraceparent and ParseCloudTraceContext expose the trace header parsing
+var (
+	ParseTraceparent       = parseTraceparent
+	ParseCloudTraceContext = parseCloudTraceContext
+)
+
+// ConfigureLogger is Configure for any Logger
+func ConfigureLogger(lg *Logger, o Options) {
+	lg.configure(o)
+}
+
+// FlushOn is FlushOnSignal for any Logger, with signals coming from ch and raise instead of re-raising them
+func FlushOn(lg *Logger, ch <-chan os.Signal, timeout time.Duration, raise func(os.Signal)) (stop func()) {
+	return lg.flushOn(ch, timeout, func() {}, raise)
+}
+
+// ResetLevels puts the default Logger's levels back to unset, so LOG_LEVEL applies again
+func ResetLevels() {
+	std.levels.mu.Lock()
+	defer std.levels.mu.Unlock()
+	std.levels.minSet = false
+	std.levels.allSet = false
+	std.levels.componentsSet = nil
+	std.levels.min = logging.Default
+	std.levels.components = nil
+}
+
+// HasLogClient reports whether InitLogging created a logging API client for lg
+func HasLogClient(lg *Logger) bool {
+	lg.apiMu.Lock()
+	defer lg.apiMu.Unlock()
+	return lg.api != nil
+}
+
+// PrintWithStack logs message with a given stack, like an error that carries one
+func PrintWithStack(lg *Logger, sev logging.Severity, message, stack string) {
+	print3(nil, lg.line(sev), message, stack, "")
+}
@@ -0,0 +1,77 @@
+package gcputils_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeLogging is a Logging API server that keeps what it's sent
+type fakeLogging struct {
+	loggingpb.UnimplementedLoggingServiceV2Server
+
+	mu   sync.Mutex
+	reqs []*loggingpb.WriteLogEntriesRequest
+	// hang, if set, holds up writes until it's closed
+	hang chan struct{}
+}
+
+func (f *fakeLogging) WriteLogEntries(ctx context.Context, req *loggingpb.WriteLogEntriesRequest) (*loggingpb.WriteLogEntriesResponse, error) {
+	if f.hang != nil {
+		select {
+		case <-f.hang:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reqs = append(f.reqs, req)
+	return &loggingpb.WriteLogEntriesResponse{}, nil
+}
+
+func (f *fakeLogging) requests() []*loggingpb.WriteLogEntriesRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*loggingpb.WriteLogEntriesRequest(nil), f.reqs...)
+}
+
+// entries returns what was logged, without the diagnostic entry the client adds once per process
+func (f *fakeLogging) entries() []*loggingpb.LogEntry {
+	var es []*loggingpb.LogEntry
+	for _, req := range f.requests() {
+		for _, e := range req.Entries {
+			if !strings.HasSuffix(e.LogName, "/diagnostic-log") {
+				es = append(es, e)
+			}
+		}
+	}
+	return es
+}
+
+// newFakeLogging starts a fakeLogging and returns the client options to connect to it
+func newFakeLogging(t *testing.T) (*fakeLogging, []option.ClientOption) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	f := &fakeLogging{}
+	loggingpb.RegisterLoggingServiceV2Server(srv, f)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, []option.ClientOption{option.WithGRPCConn(conn)}
+}
@@ -0,0 +1,247 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// CloseTimeout is how long the io.Closer from InitLogging waits for logs to be flushed, see Shutdown to pick your own deadline
+var CloseTimeout = 10 * time.Second
+
+// DroppedError is returned from Flush and Close when entries were dropped since the last call, because the
+// async queue or the logging API buffer was full.
+type DroppedError struct {
+	Dropped int64
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("gcputils: %d log entries dropped", e.Dropped)
+}
+
+// flushState keeps track of what we've already reported so each Flush only returns what's new
+type flushState struct {
+	mu           sync.Mutex
+	dropped      int64        // API overflows, and what replaced async writers dropped since the last Flush
+	async        *AsyncWriter // the writer asyncDropped and asyncErr are for
+	asyncDropped int64        // from async.Dropped() at the last Flush
+	asyncErr     error
+}
+
+// onLogError is the OnError for the logging client
+func (lg *Logger) onLogError(err error) {
+	if errors.Is(err, logging.ErrOverflow) {
+		lg.flush.mu.Lock()
+		lg.flush.dropped++
+		lg.flush.mu.Unlock()
+	}
+	h := lg.setup().onError
+	if h == nil {
+		h = stderrOnError
+	}
+	h(err)
+}
+
+// stderrOnError is the default OnError, there's nowhere else to log it
+func stderrOnError(err error) {
+	if errors.Is(err, logging.ErrOverflow) {
+		// one per dropped entry, Flush reports the total
+		return
+	}
+	fmt.Fprintf(os.Stderr, "gcputils: error writing logs: %v\n", err)
+}
+
+// Flush waits until everything logged so far has been written, or ctx is done. Call it before a short lived job
+// exits. Returns write and API errors, and a DroppedError if entries were dropped since the last call.
+func Flush(ctx context.Context) error {
+	return std.Flush(ctx)
+}
+
+// Flush waits for this Logger's async writer and logging API client to write what's queued, or for ctx to be done
+func (lg *Logger) Flush(ctx context.Context) error {
+	var errs []error
+	lg.outMu.Lock()
+	a := lg.async
+	lg.outMu.Unlock()
+	if a != nil {
+		errs = append(errs, a.Flush(ctx))
+	}
+	if api := lg.acquireAPI(); api != nil {
+		errs = append(errs, waitCtx(ctx, func() error {
+			// the ref is held until the flush is done, even if we stop waiting, so Shutdown can't close the client under it
+			defer api.refs.Done()
+			return api.logger.Flush()
+		}))
+	}
+	errs = append(errs, lg.flush.take(a))
+	return errors.Join(errs...)
+}
+
+// Shutdown flushes everything and closes the logging client and async writer, waiting at most until ctx is done.
+// Logging afterwards still works, it just goes to the output directly, or the console instead of the logging API.
+// Sampling is turned off after a final report of what it dropped.
+func Shutdown(ctx context.Context) error {
+	return std.Shutdown(ctx)
+}
+
+// Shutdown flushes and closes this Logger's async writer and logging API client, later lines go straight to the output
+func (lg *Logger) Shutdown(ctx context.Context) error {
+	var errs []error
+	// report before the outputs go away
+	lg.sampling.Swap(nil).close()
+	lg.outMu.Lock()
+	a := lg.async
+	// lines keep going through a until its queue is written, so they can't race it to a.w or get out of order
+	lg.async = nil
+	lg.outMu.Unlock()
+	if a != nil {
+		errs = append(errs, waitCtx(ctx, func() error {
+			lg.outMu.Lock()
+			defer lg.outMu.Unlock()
+			err := a.Close()
+			if lg.out == a {
+				lg.out = a.w
+			}
+			return err
+		}))
+	}
+	// new lines go to the console from here on
+	lg.apiMu.Lock()
+	api := lg.api
+	lg.api = nil
+	lg.apiMu.Unlock()
+	if api != nil {
+		errs = append(errs, api.close(ctx))
+	}
+	errs = append(errs, lg.flush.take(a))
+	return errors.Join(errs...)
+}
+
+// close closes the client once the Log and Flush calls using it are done, nobody can get a new ref by then.
+// If ctx is done first we stop waiting, and the client gets closed whenever they finish.
+func (api *apiClient) close(ctx context.Context) error {
+	return waitCtx(ctx, func() error {
+		api.refs.Wait()
+		return api.client.Close()
+	})
+}
+
+// take returns what hasn't been reported yet
+func (f *flushState) take(a *AsyncWriter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	dropped := f.dropped
+	f.dropped = 0
+	if a != f.async {
+		// Dropped and Err are per writer
+		f.async, f.asyncDropped, f.asyncErr = a, 0, nil
+	}
+	if a != nil {
+		// these only ever go up, so report the difference
+		d := a.Dropped()
+		dropped += d - f.asyncDropped
+		f.asyncDropped = d
+		if e := a.Err(); e != nil && e != f.asyncErr {
+			f.asyncErr = e
+			err = e
+		}
+	}
+	if dropped > 0 {
+		err = errors.Join(err, &DroppedError{Dropped: dropped})
+	}
+	return err
+}
+
+// retire keeps what a replaced async writer dropped since the last Flush for the next one
+func (f *flushState) retire(a *AsyncWriter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if a == f.async {
+		f.dropped += a.Dropped() - f.asyncDropped
+		f.async, f.asyncDropped, f.asyncErr = nil, 0, nil
+		return
+	}
+	f.dropped += a.Dropped()
+}
+
+// waitCtx runs f but stops waiting for it when ctx is done
+func waitCtx(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FlushOnSignal flushes the default Logger when the process gets one of sigs (SIGTERM and SIGINT by default),
+// waiting at most timeout. Cloud Run sends SIGTERM before shutting down an instance, so this keeps the last few seconds of logs.
+// Afterwards the signal is raised again so the process exits like it would have. If you handle these signals yourself,
+// call Flush in your handler instead. Call stop to stop listening.
+func FlushOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	return std.FlushOnSignal(timeout, sigs...)
+}
+
+// FlushOnSignal flushes this Logger on the first of sigs, then raises the signal again
+func (lg *Logger) FlushOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGTERM, os.Interrupt}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	return lg.flushOn(ch, timeout, func() { signal.Stop(ch) }, func(sig os.Signal) {
+		p, err := os.FindProcess(os.Getpid())
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	})
+}
+
+// flushOn flushes when ch gets a signal, then calls unsubscribe and raise
+func (lg *Logger) flushOn(ch <-chan os.Signal, timeout time.Duration, unsubscribe func(), raise func(os.Signal)) (stop func()) {
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+	go func() {
+		select {
+		case <-done:
+			return
+		case sig := <-ch:
+			select {
+			case <-done:
+				// stopped at the same time
+				return
+			default:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := lg.Flush(ctx)
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "gcputils: flushing logs on %v: %v\n", sig, err)
+			}
+			stop()
+			raise(sig)
+		}
+	}()
+	return stop
+}
@@ -0,0 +1,333 @@
+package gcputils_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/treeder/gcputils"
+)
+
+// syncBuffer is a bytes.Buffer that's safe to use from the async writer and the test
+type syncBuffer struct {
+	mu      sync.Mutex
+	b       bytes.Buffer
+	release chan struct{}
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) lines() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.Count(s.b.String(), "\n")
+}
+
+func TestShutdownWhileLogging(t *testing.T) {
+	out := &syncBuffer{}
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   out,
+		Async:    &gcputils.AsyncOptions{Overflow: gcputils.Block},
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				lg.Info().Println("hi")
+			}
+		}()
+	}
+	if err := lg.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	// whatever came after Shutdown went straight to the output
+	if err := lg.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := out.lines(); n != 800 {
+		t.Errorf("got %d lines, want 800", n)
+	}
+}
+
+func TestFlushReportsDropped(t *testing.T) {
+	out := &syncBuffer{release: make(chan struct{})}
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   out,
+		Async:    &gcputils.AsyncOptions{QueueSize: 1},
+	})
+	defer lg.Close()
+	for i := 0; i < 10; i++ {
+		lg.Info().Println("hi")
+	}
+	close(out.release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var de *gcputils.DroppedError
+	if err := lg.Flush(ctx); !errors.As(err, &de) || de.Dropped == 0 {
+		t.Fatalf("expected a DroppedError, got %v", err)
+	}
+	if n := int64(out.lines()); n+de.Dropped != 10 {
+		t.Errorf("%d written and %d dropped, want 10 total", n, de.Dropped)
+	}
+	// only reported once
+	if err := lg.Flush(ctx); err != nil {
+		t.Errorf("second Flush: %v", err)
+	}
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	out := &syncBuffer{}
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   out,
+		Async:    &gcputils.AsyncOptions{Overflow: gcputils.Block},
+	})
+	defer lg.Close()
+	ch := make(chan os.Signal, 1)
+	raised := make(chan os.Signal, 1)
+	gcputils.FlushOn(lg, ch, 5*time.Second, func(sig os.Signal) {
+		// everything logged before the signal is out by the time it's raised again
+		if n := out.lines(); n != 100 {
+			t.Errorf("got %d lines before raising, want 100", n)
+		}
+		raised <- sig
+	})
+	for i := 0; i < 100; i++ {
+		lg.Info().Println("hi")
+	}
+	ch <- syscall.SIGTERM
+	select {
+	case sig := <-raised:
+		if sig != syscall.SIGTERM {
+			t.Errorf("raised %v, want SIGTERM", sig)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal was never raised again")
+	}
+}
+
+func TestFlushOnSignalStop(t *testing.T) {
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeJSON, Output: &syncBuffer{}})
+	ch := make(chan os.Signal, 1)
+	raised := make(chan os.Signal, 1)
+	stop := gcputils.FlushOn(lg, ch, time.Second, func(sig os.Signal) { raised <- sig })
+	stop()
+	stop() // safe to call twice
+	ch <- syscall.SIGTERM
+	select {
+	case <-raised:
+		t.Fatal("signal handled after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFlushAPI(t *testing.T) {
+	srv, opts := newFakeLogging(t)
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeAPI, ProjectID: "proj"})
+	ctx := context.Background()
+	// nothing gets sent on its own before Flush
+	if err := lg.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lg.Info().Println("one")
+	lg.Info().Println("two")
+	if n := len(srv.entries()); n != 0 {
+		t.Fatalf("got %d entries before Flush, want 0", n)
+	}
+	if err := lg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.entries()); n != 2 {
+		t.Errorf("got %d entries after Flush, want 2", n)
+	}
+	lg.Info().Println("three")
+	if err := lg.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.entries()); n != 3 {
+		t.Errorf("got %d entries after Shutdown, want 3", n)
+	}
+}
+
+func TestInitLoggingAgain(t *testing.T) {
+	first, opts := newFakeLogging(t)
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeAPI, ProjectID: "proj"})
+	ctx := context.Background()
+	if err := lg.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lg.Info().Println("one")
+	// the first client's entries are written when it's replaced, not lost
+	second, opts := newFakeLogging(t)
+	if err := lg.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(first.entries()); n != 1 {
+		t.Errorf("first client got %d entries, want 1", n)
+	}
+	lg.Info().Println("two")
+	if err := lg.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(first.entries()); n != 1 {
+		t.Errorf("first client got %d entries after Shutdown, want 1", n)
+	}
+	if n := len(second.entries()); n != 1 {
+		t.Errorf("second client got %d entries, want 1", n)
+	}
+}
+
+func TestFlushAfterReconfigure(t *testing.T) {
+	out := &syncBuffer{release: make(chan struct{})}
+	o := gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   out,
+		Async:    &gcputils.AsyncOptions{QueueSize: 1},
+	}
+	lg := gcputils.New(o)
+	defer lg.Close()
+	for i := 0; i < 10; i++ {
+		lg.Info().Println("hi")
+	}
+	close(out.release)
+	// the first writer's drops are reported once, and don't hide the next writer's
+	gcputils.ConfigureLogger(lg, o)
+	var de *gcputils.DroppedError
+	ctx := context.Background()
+	if err := lg.Flush(ctx); !errors.As(err, &de) || de.Dropped == 0 {
+		t.Fatalf("expected a DroppedError from the replaced writer, got %v", err)
+	}
+	if err := lg.Flush(ctx); err != nil {
+		t.Errorf("second Flush: %v", err)
+	}
+}
+
+func TestShutdownWhileFlushHangs(t *testing.T) {
+	srv, opts := newFakeLogging(t)
+	srv.hang = make(chan struct{})
+	lg := gcputils.New(gcputils.Options{
+		Platform:  gcputils.PlatformLocal,
+		Mode:      gcputils.ModeAPI,
+		ProjectID: "proj",
+		OnError:   func(error) {},
+	})
+	if err := lg.InitLogging(context.Background(), opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lg.Info().Println("one")
+	// like FlushOnSignal with a slow API: we stop waiting, the flush keeps going
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lg.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Flush: got %v, want DeadlineExceeded", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		done <- lg.Shutdown(ctx)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown: got %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown didn't stop waiting when ctx was done")
+	}
+	logged := make(chan struct{})
+	go func() {
+		lg.Info().Println("two")
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on the hung flush")
+	}
+	if gcputils.HasLogClient(lg) {
+		t.Error("client still in use after Shutdown")
+	}
+	// the client gets closed once the flush is done
+	close(srv.hang)
+}
+
+// messages returns the message of each JSON line in b
+func messages(t *testing.T, b *bytes.Buffer) []string {
+	t.Helper()
+	var ms []string
+	for _, l := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var e struct{ Message string }
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatalf("%v: %s", err, l)
+		}
+		ms = append(ms, strings.TrimSpace(e.Message))
+	}
+	return ms
+}
+
+// checkInOrder fails unless ms is 0 to n-1
+func checkInOrder(t *testing.T, ms []string, n int) {
+	t.Helper()
+	if len(ms) != n {
+		t.Fatalf("got %d lines, want %d", len(ms), n)
+	}
+	for i, m := range ms {
+		if m != strconv.Itoa(i) {
+			t.Fatalf("line %d is %q, lines are out of order", i, m)
+		}
+	}
+}
+
+func TestShutdownKeepsOrder(t *testing.T) {
+	// a plain buffer, so -race catches two goroutines writing to it
+	var out bytes.Buffer
+	lg := gcputils.New(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   &out,
+		Async:    &gcputils.AsyncOptions{Overflow: gcputils.Block},
+	})
+	const n = 2000
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			if i == n/10 {
+				close(started)
+			}
+			lg.Info().Println(i)
+		}
+	}()
+	<-started
+	if err := lg.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	checkInOrder(t, messages(t, &out), n)
+}
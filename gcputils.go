@@ -3,6 +3,7 @@ package gcputils
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -32,10 +33,12 @@ func GetEnvVar(name, def string) string {
 	if metadata.OnGCE() {
 		e, err = metadata.ProjectAttributeValue(name)
 		if err == nil {
-			fmt.Println("GOT META", e)
 			return e
 		}
-		log.Println("error on metadata.ProjectAttributeValue", err)
+		var notDefined metadata.NotDefinedError
+		if !errors.As(err, &notDefined) {
+			log.Println("error on metadata.ProjectAttributeValue", err)
+		}
 	}
 	if def != "" {
 		return def
@@ -0,0 +1,204 @@
+package gcputils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// LevelRequest is the body for a PUT to LevelHandler
+type LevelRequest struct {
+	// Level is the new minimum severity, eg: DEBUG
+	Level string `json:"level"`
+	// Component to change, leave empty to change the global level
+	Component string `json:"component,omitempty"`
+	// TTL is optional, after this duration the level goes back to what it was, eg: 10m
+	TTL string `json:"ttl,omitempty"`
+}
+
+// LevelResponse is what LevelHandler returns
+type LevelResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components,omitempty"`
+	// Reverts are pending reverts keyed by component, empty string for the global level
+	Reverts map[string]time.Time `json:"reverts,omitempty"`
+}
+
+type levelRevert struct {
+	timer   *time.Timer
+	at      time.Time
+	prev    logging.Severity
+	hadPrev bool   // false if the component had no override before
+	gen     uint64 // bumped on every renewal so a stale timer can tell it lost
+}
+
+// levelReverts are the pending TTL reverts for a Logger, keyed by component
+type levelReverts struct {
+	mu sync.Mutex
+	m  map[string]*levelRevert
+}
+
+// LevelHandler returns an http.Handler to view and change levels on a running service.
+// Make sure to put this behind your own auth.
+//
+// GET returns the current levels.
+// PUT (or POST) changes a level, either with a JSON LevelRequest body or query params:
+//
+//	curl -X PUT -d '{"level":"debug","component":"db","ttl":"10m"}' localhost:8080/loglevel
+//	curl -X PUT 'localhost:8080/loglevel?level=debug&ttl=10m'
+//
+// DELETE ?component=x removes a component override.
+func LevelHandler() http.Handler {
+	return std.LevelHandler()
+}
+
+// LevelHandler serves GET, PUT/POST and DELETE for this Logger's levels, see the package level LevelHandler
+func (lg *Logger) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			req := LevelRequest{}
+			if r.ContentLength != 0 && r.Body != nil {
+				err := json.NewDecoder(r.Body).Decode(&req)
+				if err != nil {
+					http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else {
+				q := r.URL.Query()
+				req = LevelRequest{Level: q.Get("level"), Component: q.Get("component"), TTL: q.Get("ttl")}
+			}
+			err := lg.applyLevel(req)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			c := r.URL.Query().Get("component")
+			if c == "" {
+				// otherwise this would cancel a pending revert of the global level
+				http.Error(w, "component is required", http.StatusBadRequest)
+				return
+			}
+			lg.cancelRevert(c)
+			lg.ClearComponentLevel(c)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lg.currentLevels())
+	})
+}
+
+func (lg *Logger) applyLevel(req LevelRequest) error {
+	sev, err := ParseSeverity(req.Level)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil {
+			return err
+		}
+	}
+	rs := &lg.reverts
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rv := rs.m[req.Component]
+	if rv != nil {
+		// keep the original level from before the first change
+		rv.timer.Stop()
+		delete(rs.m, req.Component)
+	}
+	if ttl > 0 {
+		if rv == nil {
+			rv = &levelRevert{}
+			if req.Component == "" {
+				rv.prev, rv.hadPrev = lg.Level(), true
+			} else {
+				rv.prev, rv.hadPrev = lg.ComponentLevels()[req.Component]
+			}
+		}
+		c, gen := req.Component, rv.gen+1
+		rv.gen = gen
+		rv.at = time.Now().Add(ttl)
+		rv.timer = time.AfterFunc(ttl, func() { lg.revertLevel(c, gen) })
+		if rs.m == nil {
+			rs.m = map[string]*levelRevert{}
+		}
+		rs.m[c] = rv
+	}
+	lg.setLevel(req.Component, sev)
+	return nil
+}
+
+// revertLevel is called by the TTL timer. A timer that fired while the level was being
+// renewed finds a newer generation in the map and does nothing.
+func (lg *Logger) revertLevel(c string, gen uint64) {
+	lg.reverts.mu.Lock()
+	defer lg.reverts.mu.Unlock()
+	rv := lg.reverts.m[c]
+	if rv == nil || rv.gen != gen {
+		return
+	}
+	delete(lg.reverts.m, c)
+	var msg string
+	if rv.hadPrev {
+		lg.setLevel(c, rv.prev)
+		msg = fmt.Sprintf("log level reverted to %v", strings.ToUpper(rv.prev.String()))
+	} else {
+		lg.ClearComponentLevel(c)
+		msg = "log level override removed"
+	}
+	// straight to print3, the restored level may well be above NOTICE
+	l := lg.line(logging.Notice)
+	if c != "" {
+		l.fields = map[string]interface{}{"component": c}
+	}
+	print3(nil, l, msg, "", "")
+}
+
+func (lg *Logger) cancelRevert(c string) {
+	lg.reverts.mu.Lock()
+	defer lg.reverts.mu.Unlock()
+	if rv := lg.reverts.m[c]; rv != nil {
+		rv.timer.Stop()
+		delete(lg.reverts.m, c)
+	}
+}
+
+func (lg *Logger) setLevel(c string, sev logging.Severity) {
+	if c == "" {
+		lg.SetLevel(sev)
+		return
+	}
+	lg.SetComponentLevel(c, sev)
+}
+
+func (lg *Logger) currentLevels() *LevelResponse {
+	resp := &LevelResponse{Level: strings.ToUpper(lg.Level().String())}
+	for c, sev := range lg.ComponentLevels() {
+		if resp.Components == nil {
+			resp.Components = map[string]string{}
+		}
+		resp.Components[c] = strings.ToUpper(sev.String())
+	}
+	lg.reverts.mu.Lock()
+	defer lg.reverts.mu.Unlock()
+	for c, rv := range lg.reverts.m {
+		if resp.Reverts == nil {
+			resp.Reverts = map[string]time.Time{}
+		}
+		resp.Reverts[c] = rv.at
+	}
+	return resp
+}
@@ -0,0 +1,144 @@
+package gcputils_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func doLevel(t *testing.T, h http.Handler, method, target, body string) (int, gcputils.LevelResponse) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	var resp gcputils.LevelResponse
+	if w.Code == http.StatusOK {
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, resp
+}
+
+func TestLevelHandler(t *testing.T) {
+	t.Cleanup(gcputils.ResetLevels)
+	gcputils.SetLevel(logging.Info)
+	h := gcputils.LevelHandler()
+
+	tests := []struct {
+		method, target, body string
+		wantCode             int
+		wantLevel            string
+		wantDB               string
+	}{
+		{"GET", "/", "", 200, "INFO", ""},
+		{"PUT", "/?level=warning", "", 200, "WARNING", ""},
+		{"PUT", "/", `{"level":"debug","component":"db"}`, 200, "WARNING", "DEBUG"},
+		{"PUT", "/?level=nope", "", 400, "", ""},
+		{"PUT", "/?level=debug&ttl=soon", "", 400, "", ""},
+		{"PUT", "/", `{"level":`, 400, "", ""},
+		{"DELETE", "/?component=db", "", 200, "WARNING", ""},
+		{"DELETE", "/", "", 400, "", ""},
+		{"PATCH", "/", "", 405, "", ""},
+		{"PUT", "/?level=info", "", 200, "INFO", ""},
+	}
+	for _, tc := range tests {
+		code, resp := doLevel(t, h, tc.method, tc.target, tc.body)
+		if code != tc.wantCode {
+			t.Errorf("%s %s %s: code %d, want %d", tc.method, tc.target, tc.body, code, tc.wantCode)
+			continue
+		}
+		if code != 200 {
+			continue
+		}
+		if resp.Level != tc.wantLevel || resp.Components["db"] != tc.wantDB {
+			t.Errorf("%s %s %s: got %+v, want level %q db %q", tc.method, tc.target, tc.body, resp, tc.wantLevel, tc.wantDB)
+		}
+	}
+}
+
+func TestLevelHandlerTTL(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	lg.SetLevel(logging.Info)
+	h := lg.LevelHandler()
+
+	_, resp := doLevel(t, h, "PUT", "/?level=debug&ttl=100ms", "")
+	if resp.Level != "DEBUG" {
+		t.Fatalf("level = %q, want DEBUG", resp.Level)
+	}
+	if _, ok := resp.Reverts[""]; !ok {
+		t.Fatalf("expected a pending revert, got %+v", resp)
+	}
+	doLevel(t, h, "PUT", "/?level=debug&component=db&ttl=100ms", "")
+	// a DELETE without a component must not cancel the global revert
+	if code, _ := doLevel(t, h, "DELETE", "/", ""); code != http.StatusBadRequest {
+		t.Errorf("DELETE without component: code %d, want 400", code)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		_, resp = doLevel(t, h, "GET", "/", "")
+		if len(resp.Reverts) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if resp.Level != "INFO" {
+		t.Errorf("level = %q after the ttl, want INFO", resp.Level)
+	}
+	if _, ok := resp.Components["db"]; ok {
+		t.Errorf("db override should be gone after the ttl, got %+v", resp.Components)
+	}
+	if !rec.HasEntry(logging.Notice, "log level reverted to INFO") {
+		t.Errorf("expected a notice about the revert, got %+v", rec.Entries())
+	}
+	if !rec.HasEntry(logging.Notice, "log level override removed") {
+		t.Errorf("expected a notice about the removed db override, got %+v", rec.Entries())
+	}
+}
+
+func TestLevelHandlerRenew(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	lg.SetLevel(logging.Error)
+	h := lg.LevelHandler()
+
+	doLevel(t, h, "POST", "/?level=debug&ttl=100ms", "")
+	time.Sleep(50 * time.Millisecond)
+	// renewing before expiry must push the revert out instead of reverting early
+	doLevel(t, h, "PUT", "/?level=debug&ttl=400ms", "")
+	time.Sleep(150 * time.Millisecond)
+	_, resp := doLevel(t, h, "GET", "/", "")
+	if resp.Level != "DEBUG" {
+		t.Fatalf("level = %q after the first ttl, want DEBUG until the renewed ttl", resp.Level)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(resp.Reverts) > 0 {
+		time.Sleep(10 * time.Millisecond)
+		_, resp = doLevel(t, h, "GET", "/", "")
+	}
+	if resp.Level != "ERROR" {
+		t.Errorf("level = %q after the renewed ttl, want ERROR", resp.Level)
+	}
+	// the notice is logged even though ERROR filters out NOTICE
+	if !rec.HasEntry(logging.Notice, "log level reverted to ERROR") {
+		t.Errorf("expected a notice about the revert, got %+v", rec.Entries())
+	}
+}
+
+func TestLevelHandlerMethodNotAllowed(t *testing.T) {
+	lg, _ := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	w := httptest.NewRecorder()
+	lg.LevelHandler().ServeHTTP(w, httptest.NewRequest("PATCH", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("code = %d, want 405", w.Code)
+	}
+	if got := w.Header().Get("Allow"); got != "GET, PUT, POST, DELETE" {
+		t.Errorf("Allow = %q", got)
+	}
+}
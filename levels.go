@@ -0,0 +1,227 @@
+package gcputils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gotils/v2"
+)
+
+// LevelEnvVar sets the minimum severity. On GCP it's read with GetEnvVar so it can be an env var or project metadata.
+// Either just a severity or a severity followed by per component overrides, eg: LOG_LEVEL=warning,db=debug,http=info
+const LevelEnvVar = "LOG_LEVEL"
+
+// levelConfig holds a Logger's levels
+type levelConfig struct {
+	mu         sync.RWMutex
+	min        logging.Severity
+	components map[string]logging.Severity
+	// what was set explicitly, loading the env doesn't override it
+	minSet        bool
+	allSet        bool            // SetLevels replaced every component override
+	componentsSet map[string]bool // set or cleared one at a time
+}
+
+// SetLevel sets the minimum severity, anything below it is dropped. Safe to call at runtime.
+func SetLevel(sev logging.Severity) {
+	std.SetLevel(sev)
+}
+
+// SetLevel sets the minimum severity for this Logger
+func (lg *Logger) SetLevel(sev logging.Severity) {
+	lg.levels.mu.Lock()
+	defer lg.levels.mu.Unlock()
+	lg.levels.minSet = true
+	lg.levels.min = sev
+}
+
+// Level returns the minimum severity
+func Level() logging.Severity {
+	return std.Level()
+}
+
+// Level returns the minimum severity for this Logger
+func (lg *Logger) Level() logging.Severity {
+	lg.setup()
+	lg.levels.mu.RLock()
+	defer lg.levels.mu.RUnlock()
+	return lg.levels.min
+}
+
+// SetComponentLevel overrides the minimum severity for a component. The component is the value from SetComponent
+// or a "component" field.
+func SetComponentLevel(component string, sev logging.Severity) {
+	std.SetComponentLevel(component, sev)
+}
+
+// SetComponentLevel overrides the minimum severity for a component on this Logger
+func (lg *Logger) SetComponentLevel(component string, sev logging.Severity) {
+	lg.levels.mu.Lock()
+	defer lg.levels.mu.Unlock()
+	lg.levels.markSet(component)
+	if lg.levels.components == nil {
+		lg.levels.components = map[string]logging.Severity{}
+	}
+	lg.levels.components[component] = sev
+}
+
+// ClearComponentLevel removes a component override so it uses the global minimum again
+func ClearComponentLevel(component string) {
+	std.ClearComponentLevel(component)
+}
+
+// ClearComponentLevel removes a component override from this Logger
+func (lg *Logger) ClearComponentLevel(component string) {
+	lg.levels.mu.Lock()
+	defer lg.levels.mu.Unlock()
+	// stays cleared, even if the env has an override for it
+	lg.levels.markSet(component)
+	delete(lg.levels.components, component)
+}
+
+// ComponentLevels returns a copy of the per component overrides
+func ComponentLevels() map[string]logging.Severity {
+	return std.ComponentLevels()
+}
+
+// ComponentLevels returns a copy of the per component overrides for this Logger
+func (lg *Logger) ComponentLevels() map[string]logging.Severity {
+	lg.setup()
+	lg.levels.mu.RLock()
+	defer lg.levels.mu.RUnlock()
+	m := make(map[string]logging.Severity, len(lg.levels.components))
+	for k, v := range lg.levels.components {
+		m[k] = v
+	}
+	return m
+}
+
+// SetLevels sets levels from the same format as LevelEnvVar, replacing all component overrides
+func SetLevels(s string) error {
+	return std.SetLevels(s)
+}
+
+// SetLevels parses s like LevelEnvVar and sets this Logger's minimum severity and component overrides
+func (lg *Logger) SetLevels(s string) error {
+	min, components, err := ParseLevels(s)
+	if err != nil {
+		return err
+	}
+	lg.levels.mu.Lock()
+	defer lg.levels.mu.Unlock()
+	lg.levels.minSet = true
+	lg.levels.allSet = true
+	lg.levels.min = min
+	lg.levels.components = components
+	return nil
+}
+
+// ParseLevels parses the LevelEnvVar format, eg: "warning,db=debug"
+func ParseLevels(s string) (logging.Severity, map[string]logging.Severity, error) {
+	min := logging.Default
+	components := map[string]logging.Severity{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		c, sevs, ok := strings.Cut(part, "=")
+		if !ok {
+			sevs = c
+		}
+		sev, err := ParseSeverity(sevs)
+		if err != nil {
+			return logging.Default, nil, err
+		}
+		if ok {
+			components[strings.TrimSpace(c)] = sev
+		} else {
+			min = sev
+		}
+	}
+	return min, components, nil
+}
+
+// ParseSeverity is like logging.ParseSeverity, but returns an error instead of Default for bad input
+func ParseSeverity(s string) (logging.Severity, error) {
+	s = strings.TrimSpace(s)
+	sev := logging.ParseSeverity(s)
+	if sev == logging.Default && !strings.EqualFold(s, "default") {
+		return sev, fmt.Errorf("invalid severity %q", s)
+	}
+	return sev, nil
+}
+
+// markSet keeps loadFromEnv from touching component, call with mu held
+func (c *levelConfig) markSet(component string) {
+	if c.componentsSet == nil {
+		c.componentsSet = map[string]bool{}
+	}
+	c.componentsSet[component] = true
+}
+
+// loadFromEnv is called during setup, explicit Set calls win over the env for whatever they set: the minimum and each
+// component separately, so SetComponentLevel doesn't throw away the minimum from the env. Project metadata is only checked on GCP, so
+// forcing a local platform never waits on the metadata server.
+func (c *levelConfig) loadFromEnv(p Platform) {
+	s := os.Getenv(LevelEnvVar)
+	if p.OnGCP() {
+		s = GetEnvVar(LevelEnvVar, "")
+	}
+	if s == "" {
+		return
+	}
+	min, components, err := ParseLevels(s)
+	if err != nil {
+		log.Printf("gcputils: bad %v: %v", LevelEnvVar, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.minSet {
+		c.min = min
+	}
+	if c.allSet {
+		return
+	}
+	for k, v := range components {
+		if c.componentsSet[k] {
+			continue
+		}
+		if c.components == nil {
+			c.components = map[string]logging.Severity{}
+		}
+		c.components[k] = v
+	}
+}
+
+func (c *levelConfig) enabled(component string, sev logging.Severity) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	min, ok := c.components[component]
+	if !ok {
+		min = c.min
+	}
+	return sev >= min
+}
+
+// enabled checks the line against the minimum severity for its component
+func (l *line) enabled(ctx context.Context) bool {
+	lg := l.logger()
+	// loads the levels from the env
+	lg.setup()
+	c := lg.componentName()
+	if s, ok := l.fields["component"].(string); ok {
+		c = s
+	} else if ctx != nil {
+		if s, ok := gotils.Fields(ctx)["component"].(string); ok {
+			c = s
+		}
+	}
+	return lg.levels.enabled(c, l.sev)
+}
@@ -0,0 +1,160 @@
+package gcputils_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestParseLevels(t *testing.T) {
+	tests := []struct {
+		in         string
+		min        logging.Severity
+		components map[string]logging.Severity
+		wantErr    bool
+	}{
+		{"", logging.Default, map[string]logging.Severity{}, false},
+		{"warning", logging.Warning, map[string]logging.Severity{}, false},
+		{"WARNING, db=debug ,http=info", logging.Warning, map[string]logging.Severity{"db": logging.Debug, "http": logging.Info}, false},
+		{"db=error", logging.Default, map[string]logging.Severity{"db": logging.Error}, false},
+		{"loud", logging.Default, nil, true},
+		{"db=loud", logging.Default, nil, true},
+	}
+	for _, tc := range tests {
+		min, components, err := gcputils.ParseLevels(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%q: err = %v", tc.in, err)
+			continue
+		}
+		if min != tc.min || !reflect.DeepEqual(components, tc.components) {
+			t.Errorf("%q: got %v %v, want %v %v", tc.in, min, components, tc.min, tc.components)
+		}
+	}
+}
+
+func TestLevelsPerLogger(t *testing.T) {
+	tests := []struct {
+		name      string
+		levels    string
+		component string
+		field     string
+		sev       logging.Severity
+		want      bool
+	}{
+		{"below min", "warning", "", "", logging.Info, false},
+		{"at min", "warning", "", "", logging.Warning, true},
+		{"component override", "warning,db=debug", "db", "", logging.Debug, true},
+		{"component field wins", "warning,db=debug", "api", "db", logging.Debug, true},
+		{"other component", "warning,db=debug", "api", "", logging.Info, false},
+	}
+	// the group returns once all the parallel subtests are done
+	t.Run("group", func(t *testing.T) {
+		for _, tc := range tests {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+				lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, Component: tc.component})
+				if err := lg.SetLevels(tc.levels); err != nil {
+					t.Fatal(err)
+				}
+				l := lg.P(tc.sev.String())
+				if tc.field != "" {
+					l = l.F("component", tc.field)
+				}
+				l.Println("hi")
+				if got := rec.Len() == 1; got != tc.want {
+					t.Errorf("logged = %v, want %v", got, tc.want)
+				}
+			})
+		}
+	})
+	// none of that touched the default Logger
+	if gcputils.Level() != logging.Default {
+		t.Errorf("default level = %v", gcputils.Level())
+	}
+}
+
+func TestSetComponentWhileLogging(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	lg.SetComponentLevel("quiet", logging.Error)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			lg.Info().Println("hi")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		lg.SetComponent("loud")
+	}
+	wg.Wait()
+	lg.SetComponent("quiet")
+	lg.Info().Println("dropped")
+	if rec.HasEntry(logging.Info, "dropped") {
+		t.Error("expected the quiet component to be filtered")
+	}
+}
+
+func TestLevelsFromEnv(t *testing.T) {
+	t.Setenv(gcputils.LevelEnvVar, "warning,db=debug")
+	tests := []struct {
+		platform gcputils.Platform
+	}{
+		{gcputils.PlatformLocal},
+		{gcputils.PlatformCloudRun},
+	}
+	for _, tc := range tests {
+		lg := gcputils.New(gcputils.Options{Platform: tc.platform})
+		if lg.Level() != logging.Warning {
+			t.Errorf("%v: level = %v, want WARNING", tc.platform, lg.Level())
+		}
+		if got := lg.ComponentLevels()["db"]; got != logging.Debug {
+			t.Errorf("%v: db = %v, want DEBUG", tc.platform, got)
+		}
+	}
+	// explicit levels win over the env
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal})
+	lg.SetLevel(logging.Error)
+	if lg.Level() != logging.Error {
+		t.Errorf("level = %v, want ERROR", lg.Level())
+	}
+}
+
+func TestLevelsFromEnvWithOverrides(t *testing.T) {
+	t.Setenv(gcputils.LevelEnvVar, "warning,db=info,http=info")
+	tests := []struct {
+		name string
+		// logFirst logs before the overrides, so the env is loaded first
+		logFirst bool
+	}{
+		{"overrides first", false},
+		{"env first", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+			if tc.logFirst {
+				lg.Debug().Println("loads the env")
+			}
+			lg.SetComponentLevel("db", logging.Debug)
+			lg.ClearComponentLevel("http")
+			// the order doesn't matter: the minimum still comes from the env, overrides win for their components
+			if lg.Level() != logging.Warning {
+				t.Errorf("level = %v, want WARNING", lg.Level())
+			}
+			want := map[string]logging.Severity{"db": logging.Debug}
+			if got := lg.ComponentLevels(); !reflect.DeepEqual(got, want) {
+				t.Errorf("components = %v, want %v", got, want)
+			}
+			lg.Info().Println("dropped")
+			if rec.HasEntry(logging.Info, "dropped") {
+				t.Error("INFO got through a WARNING minimum")
+			}
+		})
+	}
+}
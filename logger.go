@@ -0,0 +1,179 @@
+package gcputils
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"cloud.google.com/go/logging"
+)
+
+// Logger owns its output, component, levels, default fields and project ID, so separate parts of a binary can log with
+// different settings and tests can each have their own. Lines created from a Logger write through it.
+// The package level functions use a default Logger, set up with Configure, SetComponent, SetLevel, InitLogging, etc.
+type Logger struct {
+	// mu guards cfg, which setup resolves into state
+	mu        sync.Mutex
+	cfg       Options
+	state     atomic.Pointer[resolved]
+	component atomic.Value // string, set at runtime with SetComponent
+	levels    *levelConfig
+	reverts   levelReverts
+
+	// outMu guards out so lines don't get interleaved, and async
+	outMu sync.Mutex
+	out   io.Writer
+	// async is set when Options.Async is used, so we can flush it
+	async *AsyncWriter
+
+	// apiMu guards api, the logging API client from InitLogging
+	apiMu sync.Mutex
+	api   *apiClient
+
+	sampling  atomic.Pointer[sampler]
+	redaction atomic.Pointer[redactor]
+	flush     flushState
+}
+
+// apiClient is a logging API client. Log calls and Flush hold a ref while using logger, Shutdown waits for them
+// before closing the client. Nobody has to wait on a lock while a slow flush is going on.
+type apiClient struct {
+	client *logging.Client
+	logger *logging.Logger
+	refs   sync.WaitGroup
+}
+
+// acquireAPI returns the logging API client with a ref held, or nil if there isn't one. Call refs.Done when finished.
+func (lg *Logger) acquireAPI() *apiClient {
+	lg.apiMu.Lock()
+	defer lg.apiMu.Unlock()
+	if lg.api != nil {
+		lg.api.refs.Add(1)
+	}
+	return lg.api
+}
+
+// std is the Logger behind the package level functions
+var std *Logger
+
+func init() {
+	std = New(Options{})
+}
+
+// New returns a Logger configured with o, call InitLogging on it to use the logging API
+// and Close when you're done with it.
+func New(o Options) *Logger {
+	lg := &Logger{out: os.Stderr, levels: &levelConfig{}}
+	lg.configure(o)
+	return lg
+}
+
+// Default returns the Logger used by the package level functions
+func Default() *Logger {
+	return std
+}
+
+func (lg *Logger) line(sev logging.Severity) *line {
+	return &line{sev: sev, lg: lg}
+}
+
+// P returns a new line with the provided severity
+func (lg *Logger) P(sev string) Line {
+	return lg.line(logging.ParseSeverity(sev))
+}
+
+// Debug returns a new line with DEBUG severity
+func (lg *Logger) Debug() Line {
+	return lg.line(logging.Debug)
+}
+
+// Info returns a new line with INFO severity
+func (lg *Logger) Info() Line {
+	return lg.line(logging.Info)
+}
+
+// Notice returns a new line with NOTICE severity
+func (lg *Logger) Notice() Line {
+	return lg.line(logging.Notice)
+}
+
+// Warning returns a new line with WARNING severity
+func (lg *Logger) Warning() Line {
+	return lg.line(logging.Warning)
+}
+
+// Error returns a new line with ERROR severity
+func (lg *Logger) Error() Line {
+	return lg.line(logging.Error)
+}
+
+// Critical returns a new line with CRITICAL severity
+func (lg *Logger) Critical() Line {
+	return lg.line(logging.Critical)
+}
+
+// Alert returns a new line with ALERT severity
+func (lg *Logger) Alert() Line {
+	return lg.line(logging.Alert)
+}
+
+// Emergency returns a new line with EMERGENCY severity
+func (lg *Logger) Emergency() Line {
+	return lg.line(logging.Emergency)
+}
+
+// F returns a new INFO line with the field set
+func (lg *Logger) F(key string, value interface{}) Line {
+	return lg.line(logging.Info).F(key, value)
+}
+
+// With is the same as F, since the line is new anyways
+func (lg *Logger) With(key string, value interface{}) Line {
+	return lg.F(key, value)
+}
+
+// Println logs at INFO, arguments are handled in the manner of fmt.Println
+func (lg *Logger) Println(v ...interface{}) {
+	lg.line(logging.Info).Println(v...)
+}
+
+// Print logs at INFO, arguments are handled in the manner of fmt.Print
+func (lg *Logger) Print(v ...interface{}) {
+	lg.line(logging.Info).Print(v...)
+}
+
+// Printf logs at INFO, arguments are handled in the manner of fmt.Printf
+func (lg *Logger) Printf(format string, v ...interface{}) {
+	lg.line(logging.Info).Printf(format, v...)
+}
+
+// Errorf will log an error (if it hasn't already been logged) and return an error as if fmt.Errorf was called
+func (lg *Logger) Errorf(format string, v ...interface{}) error {
+	return lg.line(logging.Error).Errorf(format, v...)
+}
+
+// Err will log an error (if it hasn't already been logged) and return the same error
+func (lg *Logger) Err(err error) error {
+	return lg.line(logging.Error).Err(err)
+}
+
+// SetComponent sets the component written with every entry, see the package level SetComponent
+func (lg *Logger) SetComponent(s string) {
+	lg.component.Store(s)
+}
+
+func (lg *Logger) componentName() string {
+	s, _ := lg.component.Load().(string)
+	return s
+}
+
+// Platform returns the platform this Logger writes for, detecting it if that hasn't happened yet
+func (lg *Logger) Platform() Platform {
+	return lg.setup().platform
+}
+
+// Mode returns the mode this Logger resolved to
+func (lg *Logger) Mode() Mode {
+	return lg.setup().mode
+}
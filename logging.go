@@ -6,26 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"os"
 	"runtime"
+	"sort"
 	"strings"
 
-	"cloud.google.com/go/compute/metadata"
 	"cloud.google.com/go/logging"
 	"github.com/treeder/gotils/v2"
 	"google.golang.org/api/option"
 )
 
-var (
-	std        *log.Logger
-	onGCE      bool
-	onCloudRun bool
-	component  string
-	clients    *clientWrapper
-)
-
 const (
 	traceHeader = "X-Cloud-Trace-Context"
 )
@@ -45,6 +35,12 @@ type Fielder interface {
 	// With clones (unlike F), then adds structured key/value pairs which will show up nicely in Cloud Logging.
 	// Use this one if you plan on passing this along to other functions or setting global fields.
 	With(string, interface{}) Line
+	// Label clones, then adds an indexed label. Labels go in logging.googleapis.com/labels instead of the payload
+	// so you can filter on them efficiently.
+	Label(key, value string) Line
+	// Operation clones, then sets the operation so Logs Explorer groups entries for a long running job.
+	// Set first on the first entry and last on the last one.
+	Operation(id, producer string, first, last bool) Line
 
 	WithTrace(r *http.Request) Line
 }
@@ -55,8 +51,18 @@ type Leveler interface {
 	Debug() Line
 	// Info returns a new logger with INFO severity
 	Info() Line
+	// Notice returns a new logger with NOTICE severity
+	Notice() Line
+	// Warning returns a new logger with WARNING severity
+	Warning() Line
 	// Error returns a new logger with ERROR severity
 	Error() Line
+	// Critical returns a new logger with CRITICAL severity
+	Critical() Line
+	// Alert returns a new logger with ALERT severity
+	Alert() Line
+	// Emergency returns a new logger with EMERGENCY severity
+	Emergency() Line
 }
 
 // Line is the main interface returned from most functions
@@ -66,132 +72,136 @@ type Line interface {
 	Leveler
 	Logf(ctx context.Context, severity, format string, a ...interface{})
 	Log(ctx context.Context, severity string, a ...interface{})
-}
-
-func init() {
-	// no prefix, no timestamp
-	std = log.New(os.Stderr, "", 0)
-	clients = &clientWrapper{}
-	onGCE = metadata.OnGCE()
-	if onGCE {
-		x, _ := metadata.InstanceAttributes()
-		fmt.Printf("InstanceAttributes: %+v\n", x)
-		s, _ := metadata.InstanceID()
-		fmt.Printf("InstanceID: %+v\n", s)
-		s, _ = metadata.InstanceName()
-		fmt.Printf("InstanceName: %+v\n", s)
-		x, _ = metadata.InstanceTags()
-		fmt.Printf("InstanceTags: %+v\n", x)
-		s, _ = metadata.Zone()
-		fmt.Printf("InstanceZone: %+v\n", s)
-		// From what I can see, instanceName is empty if on cloud run (instanceID used to be empty)
-		// On GCE, there are a couple of instance tags ([http-server https-server]) and instance attributes which appear to also be empty on cloud run
-		s, _ = metadata.InstanceName()
-		if s == "" {
-			onCloudRun = true
-		}
-	}
+	// Sample sets the key used for sampling, instead of the message template. See SetSampling.
+	Sample(key string) Line
 }
 
 // InitLogging you must call this to initialize the logging and error reporting clients.
-// Not required if using cloud run.
+// Only required on GCE or with ModeAPI, the other platforms pick up structured logs from stderr.
+// opts are passed to the logging client, eg: the ones from CredentialsAndProjectIDFromEnv.
 // Call defer x.Close() on the returned closer to ensure logs get flushed.
-func InitLogging(ctx context.Context, projectID string, opts []option.ClientOption) (io.Closer, error) {
-	clients.projectID = projectID
-	var err error
-	if onGCE {
-		if !onCloudRun {
-			clients.logClient, err = logging.NewClient(ctx, projectID)
-			if err != nil {
-				return clients, fmt.Errorf("error creating google cloud logger: %v", err)
+// Pass LoggingOptions to set the log name, resource, common labels and buffering.
+func InitLogging(ctx context.Context, projectID string, opts []option.ClientOption, lopts ...LoggingOption) (io.Closer, error) {
+	std.update(func(o *Options) { o.ProjectID = projectID })
+	return std, std.InitLogging(ctx, opts, lopts...)
+}
+
+// InitLogging creates the logging API client for this Logger, using the project ID from Options.
+// See the package level InitLogging. Calling it again closes the previous client once its entries are written.
+func (lg *Logger) InitLogging(ctx context.Context, opts []option.ClientOption, lopts ...LoggingOption) error {
+	if lg.Platform() == PlatformGCE || lg.Mode() == ModeAPI {
+		projectID := lg.setup().projectID
+		lc, err := logging.NewClient(ctx, projectID, opts...)
+		if err != nil {
+			return fmt.Errorf("error creating google cloud logger: %v", err)
+		}
+		lc.OnError = lg.onLogError
+		lo := newLoggingOptions(lopts)
+		l := lc.Logger(lo.logName, lo.loggerOptions()...)
+		lg.apiMu.Lock()
+		prev := lg.api
+		lg.api = &apiClient{client: lc, logger: l}
+		lg.apiMu.Unlock()
+		if prev != nil {
+			// called again, flush and close the old client so its entries and connection aren't lost
+			if err := prev.close(ctx); err != nil {
+				lg.onLogError(err)
 			}
-			clients.logger = clients.logClient.Logger("goapp")
-			// Setup error reporting and logging clients
-			// looks like this isn't required anymore, it will automatically do it with proper logging:
-			// https://cloud.google.com/error-reporting/docs/setup/compute-engine#go
-			// clients.errorClient, err = errorreporting.NewClient(context.Background(), projectID, errorreporting.Config{
-			// 	// ServiceName: "MyService",
-			// 	OnError: func(err error) {
-			// 		log.Printf("stackdriver: Could not log error: %v", err)
-			// 	},
-			// }, opts...)
-			// if err != nil {
-			// 	return clients, fmt.Errorf("error creating error reporting client: %v", err)
-			// }
 		}
+		// No need for an error reporting client, errors are logged as ReportedErrorEvents which Error Reporting picks up:
+		// https://cloud.google.com/error-reporting/docs/formatting-error-messages
 	}
-	return clients, nil
+	return nil
 }
 
-type clientWrapper struct {
-	projectID string
-	logClient *logging.Client
-	logger    *logging.Logger
-}
-
-func (c *clientWrapper) Close() error {
-	if c.logClient != nil {
-		c.logClient.Close()
-	}
-	return nil
+// Close flushes and closes everything, waiting at most CloseTimeout. See Shutdown.
+func (lg *Logger) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), CloseTimeout)
+	defer cancel()
+	return lg.Shutdown(ctx)
 }
 
 // SetComponent Stackdriver Log Viewer allows filtering and display of this as `jsonPayload.component`.
 func SetComponent(s string) {
-	component = s
+	std.SetComponent(s)
 }
 
 // Println take a wild guess
 func Println(v ...interface{}) {
-	l := &line{sev: logging.Info}
+	l := std.line(logging.Info)
 	l.Println(v...)
 }
 
 // Print take a wild guess
 func Print(v ...interface{}) {
-	l := &line{sev: logging.Info}
+	l := std.line(logging.Info)
 	l.Print(v...)
 }
 
 // Printf take a wild guess
 func Printf(format string, v ...interface{}) {
-	l := &line{sev: logging.Info}
+	l := std.line(logging.Info)
 	l.Printf(format, v...)
 }
 
 // P returns a new logger with the provided severity
 func P(sev string) Line {
-	return &line{sev: logging.ParseSeverity(sev)}
+	return std.P(sev)
 }
 
 // Debug returns a new logger with DEBUG severity
 func Debug() Line {
-	return &line{sev: logging.Debug}
+	return std.line(logging.Debug)
 }
 
 // Info returns a new logger with INFO severity
 func Info() Line {
-	return &line{sev: logging.Info}
+	return std.line(logging.Info)
 }
 
 func NewLogger() Line {
 	return Info()
 }
 
+// Notice returns a new logger with NOTICE severity
+func Notice() Line {
+	return std.line(logging.Notice)
+}
+
+// Warning returns a new logger with WARNING severity
+func Warning() Line {
+	return std.line(logging.Warning)
+}
+
 // Error returns a new logger with ERROR severity
 func Error() Line {
-	return &line{sev: logging.Error}
+	return std.line(logging.Error)
+}
+
+// Critical returns a new logger with CRITICAL severity
+func Critical() Line {
+	return std.line(logging.Critical)
+}
+
+// Alert returns a new logger with ALERT severity
+func Alert() Line {
+	return std.line(logging.Alert)
+}
+
+// Emergency returns a new logger with EMERGENCY severity
+func Emergency() Line {
+	return std.line(logging.Emergency)
 }
 
 // Errorf will log an error (if it hasn't already been logged) and return an error as if fmt.Errorf was called
 func Errorf(format string, v ...interface{}) error {
-	l := &line{sev: logging.Error}
+	l := std.line(logging.Error)
 	return l.Errorf(format, v...)
 }
 
 // Err will log an error (if it hasn't already been logged) and return the same error
 func Err(err error) error {
-	l := &line{sev: logging.Error}
+	l := std.line(logging.Error)
 	return l.Err(err)
 }
 
@@ -202,15 +212,39 @@ func With(key string, value interface{}) Line {
 
 // F see line.F()
 func F(key string, value interface{}) Line {
-	l := &line{sev: logging.Info}
+	l := std.line(logging.Info)
 	return l.F(key, value)
 }
 
 type line struct {
-	sev    logging.Severity
-	fields map[string]interface{}
-	trace  string
-	stack  []runtime.Frame
+	// lg is the Logger this line writes through, nil means the default one
+	lg           *Logger
+	sev          logging.Severity
+	fields       map[string]interface{}
+	trace        string
+	spanID       string
+	traceSampled bool
+	stack        []runtime.Frame
+	sampleKey    string
+	// set by Middleware for the request log
+	httpRequest *logging.HTTPRequest
+	source      *SourceLocation
+	labels      map[string]string
+	operation   *Operation
+}
+
+func (l *line) logger() *Logger {
+	if l.lg != nil {
+		return l.lg
+	}
+	return std
+}
+
+// withSource returns a shallow copy so we don't modify a line that might be shared
+func (l *line) withSource(s *SourceLocation) *line {
+	l2 := *l
+	l2.source = s
+	return &l2
 }
 
 // F adds structured key/value pairs which will show up nicely in Cloud Logging.
@@ -238,25 +272,50 @@ func (l *line) clone() *line {
 	for k, v := range l.fields {
 		l3.fields[k] = v
 	}
+	if l.labels != nil {
+		l3.labels = make(map[string]string, len(l.labels))
+		for k, v := range l.labels {
+			l3.labels[k] = v
+		}
+	}
 	return l3
 }
 
+// Label clones, then adds an indexed label. Labels go in logging.googleapis.com/labels instead of the payload
+// so you can filter on them efficiently.
+func (l *line) Label(key, value string) Line {
+	l2 := l.clone()
+	if l2.labels == nil {
+		l2.labels = map[string]string{}
+	}
+	l2.labels[key] = value
+	return l2
+}
+
+// Operation clones, then sets the operation so Logs Explorer groups entries for a long running job.
+// Set first on the first entry and last on the last one.
+func (l *line) Operation(id, producer string, first, last bool) Line {
+	l2 := l.clone()
+	l2.operation = &Operation{ID: id, Producer: producer, First: first, Last: last}
+	return l2
+}
+
 // Printf prints to the appropriate destination
 // Arguments are handled in the manner of fmt.Printf.
 func (l *line) Printf(format string, v ...interface{}) {
-	print(l, fmt.Sprintf(format, v...), "", v...)
+	print(l, format, fmt.Sprintf(format, v...), "", v...)
 }
 
 // Println prints to the appropriate destination
 // Arguments are handled in the manner of fmt.Println.
 func (l *line) Println(v ...interface{}) {
-	print(l, fmt.Sprintln(v...), "", v...)
+	print(l, template(v), fmt.Sprintln(v...), "", v...)
 }
 
 // Print prints to the appropriate destination
 // Arguments are handled in the manner of fmt.Print.
 func (l *line) Print(v ...interface{}) {
-	print(l, fmt.Sprint(v...), "", v...)
+	print(l, template(v), fmt.Sprint(v...), "", v...)
 }
 
 func (l *line) Debug() Line {
@@ -271,9 +330,33 @@ func (l *line) Info() Line {
 	return l2
 }
 
+func (l *line) Notice() Line {
+	return l.withSev(logging.Notice)
+}
+
+func (l *line) Warning() Line {
+	return l.withSev(logging.Warning)
+}
+
 func (l *line) Error() Line {
+	return l.withSev(logging.Error)
+}
+
+func (l *line) Critical() Line {
+	return l.withSev(logging.Critical)
+}
+
+func (l *line) Alert() Line {
+	return l.withSev(logging.Alert)
+}
+
+func (l *line) Emergency() Line {
+	return l.withSev(logging.Emergency)
+}
+
+func (l *line) withSev(sev logging.Severity) *line {
 	l2 := l.clone()
-	l2.sev = logging.Error
+	l2.sev = sev
 	return l2
 }
 
@@ -321,40 +404,42 @@ func (l *line) Err(err error) error {
 	if errors.As(err, &e) {
 		return err
 	}
-	l2 := l.clone()
-	l2.sev = logging.Error
-	l.Print(err)
+	l2 := l
+	if l2.sev < logging.Error {
+		l2 = l.withSev(logging.Error)
+	}
+	l2.Print(err)
 	return &loggedError{err}
 }
 
 // WithTrace adds tracing info which Cloud Logging uses to correlate logs related to a particular request
 func (l *line) WithTrace(r *http.Request) Line {
-	var trace string
-	if clients.projectID != "" { // should we log an error here since this won't work without it. "Must call InitLogging"
-		traceHeader := r.Header.Get("X-Cloud-Trace-Context")
-		traceParts := strings.Split(traceHeader, "/")
-		if len(traceParts) > 0 && len(traceParts[0]) > 0 {
-			trace = fmt.Sprintf("projects/%s/traces/%s", clients.projectID, traceParts[0])
-		}
-	}
 	l2 := *l
-	l2.trace = trace
+	ti := l.logger().traceFromRequest(r)
+	l2.trace, l2.spanID, l2.traceSampled = ti.trace, ti.spanID, ti.sampled
 	return &l2
 }
 
 // WithTrace adds tracing info which Cloud Logging uses to correlate logs related to a particular request.WithTrace
 // This is for use in conjuction with gotils contextual errors, whereas the other function with the same name
 // is used for logging.
+// Supports the traceparent and X-Cloud-Trace-Context headers as well as OpenTelemetry spans.
 func WithTrace(ctx context.Context, r *http.Request) context.Context {
-	var trace string
-	if clients.projectID != "" { // should we log an error here since this won't work without it. "Must call InitLogging"
-		traceHeader := r.Header.Get(traceHeader)
-		traceParts := strings.Split(traceHeader, "/")
-		if len(traceParts) > 0 && len(traceParts[0]) > 0 {
-			trace = fmt.Sprintf("projects/%s/traces/%s", clients.projectID, traceParts[0])
-		}
+	return std.WithTrace(ctx, r)
+}
+
+// WithTrace is the package level WithTrace using this Logger's project ID
+func (lg *Logger) WithTrace(ctx context.Context, r *http.Request) context.Context {
+	ti := lg.traceFromRequest(r)
+	ctx = gotils.With(ctx, traceHeader, ti.trace)
+	if ti.trace == "" {
+		return ctx
 	}
-	return gotils.With(ctx, traceHeader, trace)
+	if ti.spanID != "" {
+		ctx = gotils.With(ctx, spanIDKey, ti.spanID)
+	}
+	// X-Cloud-Trace-Context can be sampled without a span, eg: TRACE/0;o=1
+	return gotils.With(ctx, traceSampledKey, ti.sampled)
 }
 
 func printCtx(ctx context.Context, line *line, format string, a ...interface{}) {
@@ -374,31 +459,33 @@ func printCtx(ctx context.Context, line *line, format string, a ...interface{})
 			if errors.As(y, &stacked) {
 				// fmt.Printf("IS FULL STACKED\n")
 				line = line.clone()
-				line.sev = logging.Error
+				if line.sev < logging.Error {
+					line.sev = logging.Error
+				}
 				// then we'll output all the good stuff
 				line.stack = stacked.Stack()
 				stack = gotils.StackToString(line.stack)
 				line.fields = stacked.Fields()
-				if line.fields != nil {
-					tr := line.fields[traceHeader]
-					if tr != nil {
-						line.trace = tr.(string)
-					}
-				}
+				line.takeTrace()
 			}
 
 		}
 	}
+	if !line.enabled(ctx) || !line.sample(format) {
+		return
+	}
 	if stack == "" && line.sev >= logging.Error {
 		// buf := make([]byte, 1<<16) // 65536 - seems kinda big?
 		// i := runtime.Stack(buf, false)
 		// stack = string(buf[0:i])
 		stack = gotils.StackToString(gotils.TakeStacktrace())
 	}
+	line = line.withCaller()
 	print3(ctx, line, fmt.Sprintf(format, a...), stack, "")
 }
 
-func print(line *line, message, suffix string, args ...interface{}) {
+// template is the key used for sampling, format is used for this in Printf
+func print(line *line, template, message, suffix string, args ...interface{}) {
 	// Newer experiment based on this: https://github.com/treeder/gotils/issues/2
 	// looping through operands in case user is using %w and we already logged the error
 	stack := ""
@@ -415,26 +502,31 @@ func print(line *line, message, suffix string, args ...interface{}) {
 			if errors.As(y, &stacked) {
 				// fmt.Printf("IS FULL STACKED\n")
 				line = line.clone()
-				line.sev = logging.Error
+				if line.sev < logging.Error {
+					line.sev = logging.Error
+				}
 				// then we'll output all the good stuff
-				stack = gotils.StackToString(stacked.Stack())
+				line.stack = stacked.Stack()
+				stack = gotils.StackToString(line.stack)
 				line.fields = stacked.Fields()
-				if line.fields != nil {
-					tr := line.fields[traceHeader]
-					if tr != nil {
-						line.trace = tr.(string)
-					}
-				}
+				line.takeTrace()
 			}
 
 		}
 	}
+	if template == "" {
+		template = message
+	}
+	if !line.enabled(nil) || !line.sample(template) {
+		return
+	}
 	if stack == "" && line.sev >= logging.Error {
 		// buf := make([]byte, 1<<16) // 65536 - seems kinda big?
 		// i := runtime.Stack(buf, false)
 		// stack = string(buf[0:i])
 		stack = gotils.StackToString(gotils.TakeStacktrace())
 	}
+	line = line.withCaller()
 	print2(line, message, stack, suffix)
 }
 
@@ -444,76 +536,140 @@ func print2(line *line, message, stack, suffix string) {
 
 func print3(ctx context.Context, line *line, message, stack, suffix string) {
 	sev := line.sev
+	lg := line.logger()
+	st := lg.setup()
 	if ctx != nil {
-		// todo: merge fields from gotils context fields
-		// todo: do the same for trace (gotils.withtrace)
-		gotilsFields := gotils.Fields(ctx)
-		if gotilsFields != nil {
-			if line.fields == nil {
-				line.fields = map[string]interface{}{}
-			}
-			for k, v := range gotilsFields {
-				// which one takes precedence?
-				_, ok := line.fields[k]
-				if !ok {
-					line.fields[k] = v
-				}
-			}
+		line.addMissing(gotils.Fields(ctx))
+		if line.trace == "" {
+			ti := lg.traceFromContext(ctx)
+			line.trace, line.spanID, line.traceSampled = ti.trace, ti.spanID, ti.sampled
 		}
 	}
-	if onGCE {
-		msg := message
-		if stack != "" {
-			msg += "\n" + stack
-		}
-
-		if onCloudRun {
-			// this will automatically make an error in error reporting
-			std.Println(Entry{
-				Severity:  sev.String(),
-				Message:   msg,
-				Component: component,
-				Trace:     line.trace, // see https://cloud.google.com/run/docs/logging#writing_structured_logs
-				Fields:    line.fields,
-			})
-			return
+	// the Logger's default fields
+	line.addMissing(st.fields)
+	// trace set with WithTrace(ctx, r)
+	line.takeTrace()
+	if r := lg.redaction.Load(); r != nil {
+		line, message = r.redact(line, message)
+		// error stacks can carry messages and paths too
+		stack = r.string(stack)
+	}
+	msg := message
+	if stack != "" {
+		msg += "\n" + stack
+	}
+	platform := st.platform
+	var errType string
+	var errCtx *ErrorContext
+	var svcCtx *ServiceContext
+	if sev >= logging.Error && platform.needsErrorEvent() {
+		errType, errCtx, svcCtx = reportedErrorEventType, line.errorContext(), &st.serviceCtx
+	}
+	e := &Entry{
+		Severity:       sev.String(),
+		Message:        msg,
+		Component:      lg.componentName(),
+		Trace:          line.trace, // see https://cloud.google.com/run/docs/logging#writing_structured_logs
+		SpanID:         line.spanID,
+		TraceSampled:   line.traceSampled,
+		HTTPRequest:    toHTTPRequest(line.httpRequest),
+		SourceLocation: line.source,
+		Labels:         line.labels,
+		Operation:      line.operation,
+		Type:           errType,
+		ServiceContext: svcCtx,
+		Context:        errCtx,
+		Fields:         line.fields,
+	}
+	switch mode := st.mode; {
+	case st.sink != nil:
+		e.Stack = stack
+		if err := st.sink.WriteEntry(e); err != nil {
+			lg.onLogError(err)
 		}
-		// regular GCE, so using the APIs
-		if clients.logger == nil {
-			// InitLogging wasn't called, so printing to console
-			// todo: Maybe print a message that user should call InitLogging?
-			toConsole(ctx, line, message)
+	case mode == ModeJSON, mode == ModeAuto && platform.structured():
+		// on Cloud Run this will automatically make an error in error reporting, elsewhere the @type does it
+		lg.writeEntry(e)
+	case mode == ModeAPI, mode == ModeAuto && platform == PlatformGCE:
+		// regular GCE or asked for explicitly, so using the APIs
+		api := lg.acquireAPI()
+		if api == nil {
+			// InitLogging wasn't called, or we've shut down
+			toConsole(line, message, stack)
 			return
 		}
-		payload := map[string]interface{}{"message": msg}
-		if line.fields != nil {
-			for k, v := range line.fields {
-				payload[k] = v
-			}
-		}
-
-		clients.logger.Log(logging.Entry{
-			Severity: sev,
-			// Payload:  "something terrible happened!",
-			Payload: payload,
+		defer api.refs.Done()
+		// encode it ourselves, the client drops the whole entry if any field fails json.Marshal
+		payload := json.RawMessage(appendPayload(nil, msg, line.fields, errType, svcCtx, errCtx))
+		api.logger.Log(logging.Entry{
+			Severity:       sev,
+			Payload:        payload,
+			Trace:          line.trace,
+			SpanID:         line.spanID,
+			TraceSampled:   line.traceSampled,
+			HTTPRequest:    line.httpRequest,
+			SourceLocation: line.source.proto(),
+			Labels:         line.labels,
+			Operation:      line.operation.proto(),
 		})
 		// lg.Flush()
-		return
+	default:
+		// now just regular console, this includes GCE when InitLogging wasn't called
+		// todo: Maybe print a message that user should call InitLogging?
+		toConsole(line, message, stack)
 	}
-	// now just regular console
-	toConsole(ctx, line, message)
 }
 
-func toConsole(ctx context.Context, line *line, message string) {
-	gotils.PrintMFS(ctx, line.sev.String(), message, line.fields, line.stack)
+// addMissing adds fields the line doesn't already have, copying first since the line's fields could be shared,
+// eg: from a gotils error
+func (l *line) addMissing(extra map[string]interface{}) {
+	if len(extra) == 0 {
+		return
+	}
+	fields := make(map[string]interface{}, len(l.fields)+len(extra))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	l.fields = fields
 }
 
-func shouldSkip(s string) bool {
-	// fmt.Println("should skip: ", s)
-	if strings.HasPrefix(s, "github.com/treeder/gcputils") {
-		return true
+// toConsole prints in the same format as gotils, but with the stack for errors
+func toConsole(line *line, message, stack string) {
+	var msg strings.Builder
+	msg.WriteString(strings.ToUpper(line.sev.String()))
+	msg.WriteString("\t")
+	if line.source != nil {
+		msg.WriteString(line.source.short())
+		msg.WriteString(": ")
+	}
+	msg.WriteString(strings.TrimSuffix(message, "\n"))
+	msg.WriteString("\n")
+	if len(line.fields) > 0 {
+		keys := make([]string, 0, len(line.fields))
+		for k := range line.fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&msg, "\t%v: %v\n", k, line.fields[k])
+		}
+	}
+	if len(line.labels) > 0 {
+		fmt.Fprintf(&msg, "\tlabels: %v\n", line.labels)
 	}
-	return false
+	if line.operation != nil {
+		fmt.Fprintf(&msg, "\toperation: %v\n", line.operation.ID)
+	}
+	if stack != "" {
+		msg.WriteString(stack)
+		msg.WriteString("\n")
+	}
+	fmt.Print(msg.String())
 }
 
 type arbFields map[string]interface{}
@@ -523,40 +679,43 @@ type Entry struct {
 	Message  string `json:"message"`
 	Severity string `json:"severity,omitempty"`
 	Trace    string `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID   string `json:"logging.googleapis.com/spanId,omitempty"`
+	// TraceSampled is only written if there's a trace
+	TraceSampled bool `json:"logging.googleapis.com/trace_sampled,omitempty"`
 
 	// Stackdriver Log Viewer allows filtering and display of this as `jsonPayload.component`.
 	Component string `json:"component,omitempty"`
 
+	HTTPRequest *HTTPRequest `json:"httpRequest,omitempty"`
+
+	SourceLocation *SourceLocation `json:"logging.googleapis.com/sourceLocation,omitempty"`
+
+	// Labels are indexed, unlike Fields which end up in jsonPayload
+	Labels    map[string]string `json:"logging.googleapis.com/labels,omitempty"`
+	Operation *Operation        `json:"logging.googleapis.com/operation,omitempty"`
+
+	// Type, ServiceContext and Context make this a ReportedErrorEvent for Error Reporting
+	Type           string          `json:"@type,omitempty"`
+	ServiceContext *ServiceContext `json:"serviceContext,omitempty"`
+	Context        *ErrorContext   `json:"context,omitempty"`
+
 	Fields map[string]interface{}
+
+	// Stack is already at the end of Message, this has it on its own for sinks
+	Stack string `json:"-"`
 }
 
-// added this so we could add arbitrary fields too
-func (e Entry) flatten(m map[string]interface{}) {
-	m["message"] = e.Message
-	m["severity"] = e.Severity
-	if e.Trace != "" {
-		m["logging.googleapis.com/trace"] = e.Trace
-	}
-	if e.Component != "" {
-		m["component"] = e.Component
-	}
-	if e.Fields != nil {
-		for k, v := range e.Fields {
-			m[k] = v
-		}
-	}
+// Sink receives every entry instead of the usual outputs, see Options.Sink and the logtest package
+type Sink interface {
+	WriteEntry(e *Entry) error
 }
 
 // String renders an entry structure to the JSON format expected by Stackdriver.
 func (e Entry) String() string {
-	if e.Severity == "" {
-		e.Severity = "INFO"
-	}
-	m := map[string]interface{}{}
-	e.flatten(m)
-	out, err := json.Marshal(m)
-	if err != nil {
-		log.Printf("json.Marshal: %v", err)
-	}
-	return string(out)
+	bp := bufPool.Get().(*[]byte)
+	b := e.appendJSON((*bp)[:0])
+	s := string(b)
+	*bp = b
+	bufPool.Put(bp)
+	return s
 }
@@ -0,0 +1,144 @@
+package gcputils_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/treeder/gcputils"
+)
+
+func TestInitLoggingMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       gcputils.Mode
+		wantClient bool
+	}{
+		{"json", gcputils.ModeJSON, false},
+		{"console", gcputils.ModeConsole, false},
+		// off GCE the API is only used when asked for, and the client options point it at the fake
+		{"api", gcputils.ModeAPI, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, opts := newFakeLogging(t)
+			lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: tc.mode, ProjectID: "proj", Output: &syncBuffer{}})
+			ctx := context.Background()
+			if err := lg.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			if got := gcputils.HasLogClient(lg); got != tc.wantClient {
+				t.Fatalf("client created = %v, want %v", got, tc.wantClient)
+			}
+			lg.Info().Println("hi")
+			if err := lg.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if tc.wantClient {
+				want = 1
+			}
+			if n := len(srv.entries()); n != want {
+				t.Errorf("got %d entries on the API, want %d", n, want)
+			}
+		})
+	}
+}
+
+// severityLevels are the package functions and Line methods for each severity, ERROR and up capture a stack
+var severityLevels = []struct {
+	sev    logging.Severity
+	pkg    func() gcputils.Line
+	method func(gcputils.Line) gcputils.Line
+	stack  bool
+}{
+	{logging.Debug, gcputils.Debug, gcputils.Line.Debug, false},
+	{logging.Info, gcputils.Info, gcputils.Line.Info, false},
+	{logging.Notice, gcputils.Notice, gcputils.Line.Notice, false},
+	{logging.Warning, gcputils.Warning, gcputils.Line.Warning, false},
+	{logging.Error, gcputils.Error, gcputils.Line.Error, true},
+	{logging.Critical, gcputils.Critical, gcputils.Line.Critical, true},
+	{logging.Alert, gcputils.Alert, gcputils.Line.Alert, true},
+	{logging.Emergency, gcputils.Emergency, gcputils.Line.Emergency, true},
+}
+
+func TestSeverities(t *testing.T) {
+	for _, mode := range []gcputils.Mode{gcputils.ModeJSON, gcputils.ModeAPI, gcputils.ModeConsole} {
+		for _, tc := range severityLevels {
+			t.Run(mode.String()+"/"+tc.sev.String(), func(t *testing.T) {
+				paths := map[string]func(lg *gcputils.Logger){
+					"package": func(*gcputils.Logger) { tc.pkg().Println("hi") },
+					"method":  func(lg *gcputils.Logger) { tc.method(lg.Info()).Println("hi") },
+				}
+				for name, log := range paths {
+					sev, msg := logOne(t, mode, log)
+					if want := strings.ToUpper(tc.sev.String()); sev != want {
+						t.Errorf("%s: severity = %s, want %s", name, sev, want)
+					}
+					if !strings.HasPrefix(msg, "hi") {
+						t.Errorf("%s: message = %q", name, msg)
+					}
+					if got := strings.Contains(msg, "goroutine 1 [running]:"); got != tc.stack {
+						t.Errorf("%s: has stack = %v, want %v: %q", name, got, tc.stack, msg)
+					}
+				}
+			})
+		}
+	}
+}
+
+// logOne sets up the default Logger and a new one with mode, then returns the severity and message of the one
+// entry log writes to either of them
+func logOne(t *testing.T, mode gcputils.Mode, log func(lg *gcputils.Logger)) (sev, msg string) {
+	t.Helper()
+	ctx := context.Background()
+	o := gcputils.Options{Platform: gcputils.PlatformLocal, Mode: mode, ProjectID: "proj"}
+	out := &syncBuffer{}
+	o.Output = out
+	gcputils.Configure(o)
+	defer gcputils.Configure(gcputils.Options{})
+	lg := gcputils.New(o)
+	switch mode {
+	case gcputils.ModeConsole:
+		out := captureStdout(t, func() { log(lg) })
+		sev, msg, _ = strings.Cut(out, "\t")
+		// after the file:line
+		_, msg, _ = strings.Cut(msg, ": ")
+		return sev, msg
+	case gcputils.ModeAPI:
+		// each gets its own server, closing a client closes its connection
+		var srvs []*fakeLogging
+		for _, l := range []*gcputils.Logger{gcputils.Default(), lg} {
+			srv, opts := newFakeLogging(t)
+			if err := l.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			srvs = append(srvs, srv)
+		}
+		log(lg)
+		var es []*loggingpb.LogEntry
+		for i, l := range []*gcputils.Logger{gcputils.Default(), lg} {
+			if err := l.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			es = append(es, srvs[i].entries()...)
+		}
+		if len(es) != 1 {
+			t.Fatalf("got %d entries, want 1", len(es))
+		}
+		return es[0].Severity.String(), es[0].GetJsonPayload().GetFields()["message"].GetStringValue()
+	}
+	log(lg)
+	var e struct {
+		Severity string `json:"severity"`
+		Message  string `json:"message"`
+	}
+	if err := json.Unmarshal(out.b.Bytes(), &e); err != nil {
+		t.Fatalf("%v: %s", err, out.b.String())
+	}
+	return strings.ToUpper(e.Severity), e.Message
+}
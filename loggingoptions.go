@@ -0,0 +1,93 @@
+package gcputils
+
+import (
+	"time"
+
+	"cloud.google.com/go/logging"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// DefaultLogName is the log name used by InitLogging unless you pass LogName
+const DefaultLogName = "goapp"
+
+// LoggingOption configures the Logging API logger created by InitLogging
+type LoggingOption func(*loggingOptions)
+
+type loggingOptions struct {
+	logName  string
+	resource *mrpb.MonitoredResource
+	labels   map[string]string
+	opts     []logging.LoggerOption
+}
+
+// LogName sets the log name entries are written to, defaults to DefaultLogName.
+// Use a different one per service so they don't all end up in the same log.
+func LogName(name string) LoggingOption {
+	return func(o *loggingOptions) {
+		o.logName = name
+	}
+}
+
+// MonitoredResource sets the resource entries are attached to. If not set, the logging client detects it,
+// eg: gce_instance or k8s_container.
+func MonitoredResource(r *mrpb.MonitoredResource) LoggingOption {
+	return func(o *loggingOptions) {
+		o.resource = r
+	}
+}
+
+// CommonLabels are added to every entry, labels set on a line win over these
+func CommonLabels(labels map[string]string) LoggingOption {
+	return func(o *loggingOptions) {
+		o.labels = labels
+	}
+}
+
+// DelayThreshold is the longest entries are buffered before being sent, defaults to 1 second
+func DelayThreshold(d time.Duration) LoggingOption {
+	return func(o *loggingOptions) {
+		o.opts = append(o.opts, logging.DelayThreshold(d))
+	}
+}
+
+// EntryCountThreshold is how many entries get buffered before they're sent, defaults to 1000
+func EntryCountThreshold(n int) LoggingOption {
+	return func(o *loggingOptions) {
+		o.opts = append(o.opts, logging.EntryCountThreshold(n))
+	}
+}
+
+// BufferedByteLimit is the most that gets held in memory before entries are dropped, defaults to 1GiB
+func BufferedByteLimit(n int) LoggingOption {
+	return func(o *loggingOptions) {
+		o.opts = append(o.opts, logging.BufferedByteLimit(n))
+	}
+}
+
+// LoggerOptions passes any other logging.LoggerOption through to the logger
+func LoggerOptions(opts ...logging.LoggerOption) LoggingOption {
+	return func(o *loggingOptions) {
+		o.opts = append(o.opts, opts...)
+	}
+}
+
+func newLoggingOptions(opts []LoggingOption) *loggingOptions {
+	o := &loggingOptions{logName: DefaultLogName}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// loggerOptions turns our options into the ones for logging.Client.Logger
+func (o *loggingOptions) loggerOptions() []logging.LoggerOption {
+	var opts []logging.LoggerOption
+	if o.resource != nil {
+		opts = append(opts, logging.CommonResource(o.resource))
+	}
+	if len(o.labels) > 0 {
+		opts = append(opts, logging.CommonLabels(o.labels))
+	}
+	// ours go last so they win
+	return append(opts, o.opts...)
+}
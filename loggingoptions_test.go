@@ -0,0 +1,75 @@
+package gcputils_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/treeder/gcputils"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+func TestLoggingOptions(t *testing.T) {
+	resource := &mrpb.MonitoredResource{Type: "generic_task", Labels: map[string]string{"job": "sync"}}
+	tests := []struct {
+		name         string
+		lopts        []gcputils.LoggingOption
+		wantLogName  string
+		wantLabels   map[string]string
+		wantResource *mrpb.MonitoredResource
+	}{
+		{
+			name:        "defaults",
+			wantLogName: "projects/proj/logs/" + gcputils.DefaultLogName,
+		},
+		{
+			name: "all set",
+			lopts: []gcputils.LoggingOption{
+				gcputils.LogName("worker"),
+				gcputils.MonitoredResource(resource),
+				gcputils.CommonLabels(map[string]string{"env": "prod", "team": "a"}),
+			},
+			wantLogName:  "projects/proj/logs/worker",
+			wantLabels:   map[string]string{"env": "prod", "team": "a"},
+			wantResource: resource,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, opts := newFakeLogging(t)
+			lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeAPI, ProjectID: "proj"})
+			ctx := context.Background()
+			lopts := append(tc.lopts, gcputils.DelayThreshold(time.Hour))
+			if err := lg.InitLogging(ctx, opts, lopts...); err != nil {
+				t.Fatal(err)
+			}
+			lg.Info().Label("team", "b").Println("hi")
+			if err := lg.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			var req *loggingpb.WriteLogEntriesRequest
+			for _, r := range srv.requests() {
+				if r.LogName == tc.wantLogName {
+					req = r
+				}
+			}
+			if req == nil {
+				t.Fatalf("no entries written to %s: %v", tc.wantLogName, srv.requests())
+			}
+			// common labels go on the request, the server merges them with the entry's own
+			if !reflect.DeepEqual(req.Labels, tc.wantLabels) {
+				t.Errorf("common labels = %v, want %v", req.Labels, tc.wantLabels)
+			}
+			if want := map[string]string{"team": "b"}; !reflect.DeepEqual(req.Entries[0].Labels, want) {
+				t.Errorf("entry labels = %v, want %v", req.Entries[0].Labels, want)
+			}
+			if tc.wantResource != nil {
+				if r := req.Resource; r.GetType() != tc.wantResource.Type || !reflect.DeepEqual(r.GetLabels(), tc.wantResource.Labels) {
+					t.Errorf("resource = %v, want %v", r, tc.wantResource)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Package logtest records log entries in memory so tests can check what was logged.
+//
+//	func TestCharge(t *testing.T) {
+//		lg, rec := logtest.NewLogger(gcputils.Options{})
+//		charge(lg, card)
+//		if !rec.HasEntry(logging.Error, "declined") {
+//			t.Errorf("expected an error, got: %v", rec.Entries())
+//		}
+//	}
+package logtest
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+)
+
+// Recorder is a gcputils.Sink that keeps every entry in memory. Entries are recorded after redaction,
+// so you can check that too.
+type Recorder struct {
+	mu      sync.Mutex
+	entries []gcputils.Entry
+}
+
+// NewRecorder returns an empty Recorder, set it as Options.Sink
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewLogger returns a Logger that writes to a new Recorder. It has its own levels, sampling and redaction,
+// so it's safe for parallel tests.
+func NewLogger(o gcputils.Options) (*gcputils.Logger, *Recorder) {
+	r := NewRecorder()
+	o.Sink = r
+	return gcputils.New(o), r
+}
+
+// Configure points the package level functions at a new Recorder. Call gcputils.Configure again when you're done.
+func Configure(o gcputils.Options) *Recorder {
+	r := NewRecorder()
+	o.Sink = r
+	gcputils.Configure(o)
+	return r
+}
+
+// WriteEntry implements gcputils.Sink
+func (r *Recorder) WriteEntry(e *gcputils.Entry) error {
+	// copy since the fields can be shared with the line that logged them
+	e2 := copyEntry(*e)
+	r.mu.Lock()
+	r.entries = append(r.entries, e2)
+	r.mu.Unlock()
+	return nil
+}
+
+// Entries returns a copy of everything recorded so far
+func (r *Recorder) Entries() []gcputils.Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	es := make([]gcputils.Entry, len(r.entries))
+	for i, e := range r.entries {
+		es[i] = copyEntry(e)
+	}
+	return es
+}
+
+// copyEntry copies the maps and the pointers the entry owns, so changing one entry doesn't change another.
+// Field values are copied if they're maps or slices, other pointers in fields are still shared.
+func copyEntry(e gcputils.Entry) gcputils.Entry {
+	if e.Fields != nil {
+		e.Fields = copyValue(e.Fields).(map[string]interface{})
+	}
+	if e.Labels != nil {
+		e.Labels = copyValue(e.Labels).(map[string]string)
+	}
+	if e.HTTPRequest != nil {
+		r := *e.HTTPRequest
+		e.HTTPRequest = &r
+	}
+	if e.SourceLocation != nil {
+		sl := *e.SourceLocation
+		e.SourceLocation = &sl
+	}
+	if e.Operation != nil {
+		op := *e.Operation
+		e.Operation = &op
+	}
+	if e.ServiceContext != nil {
+		sc := *e.ServiceContext
+		e.ServiceContext = &sc
+	}
+	if e.Context != nil {
+		c := *e.Context
+		if c.ReportLocation != nil {
+			rl := *c.ReportLocation
+			c.ReportLocation = &rl
+		}
+		e.Context = &c
+	}
+	return e
+}
+
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			m[k] = copyValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(x))
+		for i := range x {
+			s[i] = copyValue(x[i])
+		}
+		return s
+	case map[string]string:
+		m := make(map[string]string, len(x))
+		for k, v := range x {
+			m[k] = v
+		}
+		return m
+	case []string:
+		return append([]string(nil), x...)
+	case http.Header:
+		return x.Clone()
+	}
+	return v
+}
+
+// Len returns the number of entries recorded
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.entries)
+}
+
+// Reset drops everything recorded so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
+
+// Find returns the entries that match f
+func (r *Recorder) Find(f func(e gcputils.Entry) bool) []gcputils.Entry {
+	var found []gcputils.Entry
+	for _, e := range r.Entries() {
+		if f(e) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// HasEntry returns true if an entry with severity sev has substr in its message
+func (r *Recorder) HasEntry(sev logging.Severity, substr string) bool {
+	return len(r.Find(func(e gcputils.Entry) bool {
+		return Severity(e) == sev && strings.Contains(e.Message, substr)
+	})) > 0
+}
+
+// HasField returns true if any entry has the field key set to value
+func (r *Recorder) HasField(key string, value interface{}) bool {
+	return len(r.Find(func(e gcputils.Entry) bool {
+		v, ok := e.Fields[key]
+		return ok && reflect.DeepEqual(v, value)
+	})) > 0
+}
+
+// Severity parses the entry's severity
+func Severity(e gcputils.Entry) logging.Severity {
+	return logging.ParseSeverity(e.Severity)
+}
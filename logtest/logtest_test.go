@@ -0,0 +1,115 @@
+package logtest_test
+
+import (
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestRecorder(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	l := lg.Warning().F("user", "bob").Label("env", "test")
+	l.Printf("card %v declined", 42)
+	lg.Info().F("attempt", 2).Print("retrying")
+
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"HasEntry", rec.HasEntry(logging.Warning, "declined"), true},
+		{"HasEntry wrong severity", rec.HasEntry(logging.Error, "declined"), false},
+		{"HasEntry other message", rec.HasEntry(logging.Warning, "retrying"), false},
+		{"HasField", rec.HasField("user", "bob"), true},
+		{"HasField int", rec.HasField("attempt", 2), true},
+		{"HasField wrong type", rec.HasField("attempt", int64(2)), false},
+		{"HasField missing", rec.HasField("nope", nil), false},
+	}
+	for _, tc := range tests {
+		if tc.got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+	if rec.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", rec.Len())
+	}
+	es := rec.Find(func(e gcputils.Entry) bool { return logtest.Severity(e) == logging.Warning })
+	if len(es) != 1 || es[0].Labels["env"] != "test" {
+		t.Errorf("Find = %+v", es)
+	}
+	// entries are copies, changing one doesn't change what's recorded
+	es[0].Fields["user"] = "mallory"
+	es[0].Labels["env"] = "prod"
+	if !rec.HasField("user", "bob") || rec.Entries()[0].Labels["env"] != "test" {
+		t.Error("recorded entry was modified through a returned copy")
+	}
+	rec.Reset()
+	if rec.Len() != 0 || len(rec.Entries()) != 0 {
+		t.Errorf("Reset left %d entries", rec.Len())
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	rec := logtest.Configure(gcputils.Options{Platform: gcputils.PlatformLocal})
+	defer gcputils.Configure(gcputils.Options{})
+	gcputils.Error().F("order", "o-1").Println("failed")
+	if !rec.HasEntry(logging.Error, "failed") || !rec.HasField("order", "o-1") {
+		t.Errorf("got %+v", rec.Entries())
+	}
+}
+
+func TestParallelLoggers(t *testing.T) {
+	for _, c := range []string{"a", "b", "c"} {
+		c := c
+		t.Run(c, func(t *testing.T) {
+			t.Parallel()
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, Component: c})
+			lg.SetLevel(logging.Warning)
+			for i := 0; i < 50; i++ {
+				lg.Info().Println("dropped")
+				lg.Warning().Println("kept")
+			}
+			if rec.Len() != 50 {
+				t.Errorf("got %d entries, want 50", rec.Len())
+			}
+			for _, e := range rec.Entries() {
+				if e.Component != c {
+					t.Errorf("component = %q, want %q", e.Component, c)
+				}
+			}
+		})
+	}
+}
+
+func TestRecorderCopies(t *testing.T) {
+	rec := logtest.NewRecorder()
+	meta := map[string]interface{}{"tags": []string{"a"}, "n": map[string]interface{}{"x": 1}}
+	e := &gcputils.Entry{
+		Message:        "hi",
+		Fields:         map[string]interface{}{"meta": meta},
+		HTTPRequest:    &gcputils.HTTPRequest{Status: 200},
+		SourceLocation: &gcputils.SourceLocation{Line: 1},
+		Operation:      &gcputils.Operation{ID: "op"},
+	}
+	rec.WriteEntry(e)
+	// the caller reusing what it logged doesn't change the recording
+	meta["tags"].([]string)[0] = "changed"
+	meta["n"].(map[string]interface{})["x"] = 2
+	e.HTTPRequest.Status = 500
+	e.SourceLocation.Line = 2
+	e.Operation.ID = "other"
+	// and neither does changing what Entries returned
+	got := rec.Entries()[0]
+	got.Fields["meta"].(map[string]interface{})["n"] = nil
+
+	got = rec.Entries()[0]
+	m := got.Fields["meta"].(map[string]interface{})
+	if m["tags"].([]string)[0] != "a" || m["n"].(map[string]interface{})["x"] != 1 {
+		t.Errorf("fields changed: %v", m)
+	}
+	if got.HTTPRequest.Status != 200 || got.SourceLocation.Line != 1 || got.Operation.ID != "op" {
+		t.Errorf("entry changed: %+v", got)
+	}
+}
@@ -0,0 +1,169 @@
+package gcputils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// HTTPRequest is the httpRequest field of a structured log entry, Cloud Logging shows these nicely as request logs.
+// See https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+type HTTPRequest struct {
+	RequestMethod string `json:"requestMethod,omitempty"`
+	RequestURL    string `json:"requestUrl,omitempty"`
+	RequestSize   int64  `json:"requestSize,string,omitempty"`
+	Status        int    `json:"status,omitempty"`
+	ResponseSize  int64  `json:"responseSize,string,omitempty"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	Latency       string `json:"latency,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+func toHTTPRequest(r *logging.HTTPRequest) *HTTPRequest {
+	if r == nil || r.Request == nil {
+		return nil
+	}
+	return &HTTPRequest{
+		RequestMethod: r.Request.Method,
+		RequestURL:    r.Request.URL.String(),
+		RequestSize:   r.RequestSize,
+		Status:        r.Status,
+		ResponseSize:  r.ResponseSize,
+		UserAgent:     r.Request.UserAgent(),
+		RemoteIP:      r.RemoteIP,
+		Referer:       r.Request.Referer(),
+		Latency:       fmt.Sprintf("%.9fs", r.Latency.Seconds()),
+		Protocol:      r.Request.Proto,
+	}
+}
+
+// Middleware sets up the trace and a request scoped Line in the context, then logs one request entry per request
+// with status, latency, sizes, etc. 5xx responses are logged as WARNING, everything else as INFO.
+//
+//	http.ListenAndServe(":8080", gcputils.Middleware(mux))
+func Middleware(next http.Handler) http.Handler {
+	return std.Middleware(next)
+}
+
+// Middleware is the package level Middleware, with the request scoped Line writing through this Logger
+func (lg *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		l := lg.Info().WithTrace(r)
+		ctx := lg.WithTrace(r.Context(), r)
+		ctx = NewContext(ctx, l)
+		rw := &responseWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		l2 := l.(*line).clone()
+		if rw.status >= 500 {
+			l2.sev = logging.Warning
+		}
+		// a copy, so the handler's request isn't touched
+		req := *r
+		req.URL = absoluteURL(r)
+		l2.httpRequest = &logging.HTTPRequest{
+			Request:      &req,
+			RequestSize:  max(r.ContentLength, 0),
+			Status:       rw.status,
+			ResponseSize: rw.size,
+			Latency:      time.Since(start),
+			RemoteIP:     remoteIP(r, lg.setup().forwardedHops),
+		}
+		// sampled per route, not all together under the format
+		l2.sampleKey = r.Method + " " + r.URL.Path
+		l2.Printf("%v %v %v", r.Method, r.URL.Path, rw.status)
+	})
+}
+
+// absoluteURL is the URL the client asked for, server requests only have the path and query.
+// X-Forwarded-Proto wins over TLS since Cloud Run and the load balancers terminate TLS before us.
+func absoluteURL(r *http.Request) *url.URL {
+	u := *r.URL
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+		if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+			p, _, _ = strings.Cut(p, ",")
+			u.Scheme = strings.ToLower(strings.TrimSpace(p))
+		}
+	}
+	return &u
+}
+
+// remoteIP uses X-Forwarded-For since we're usually behind Google's load balancers. Clients can send their own
+// X-Forwarded-For, which the proxies append to, so only the last hops entries are trusted and we take the first of those.
+func remoteIP(r *http.Request, hops int) string {
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		ips := strings.Split(strings.Join(fwd, ","), ",")
+		i := max(len(ips)-hops, 0)
+		return strings.TrimSpace(ips[i])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// responseWriter records the status and size for the request log
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			// flushing sends the headers
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("gcputils: ResponseWriter does not implement http.Hijacker")
+}
+
+// Unwrap is for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,173 @@
+package gcputils_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		body     string
+		headers  map[string]string
+		status   int
+		size     int64
+		sev      logging.Severity
+		remoteIP string
+		// hops is Options.ForwardedHops
+		hops int
+	}{
+		{
+			name:    "implicit 200",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) },
+			status:  200, size: 5, sev: logging.Info, remoteIP: "192.0.2.1",
+		},
+		{
+			name:    "no write",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			status:  200, sev: logging.Info, remoteIP: "192.0.2.1",
+		},
+		{
+			name:    "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			status:  404, size: 19, sev: logging.Info, remoteIP: "192.0.2.1",
+		},
+		{
+			name: "5xx is a warning",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(503)
+				// only the first status counts
+				w.WriteHeader(200)
+			},
+			status: 503, sev: logging.Warning, remoteIP: "192.0.2.1",
+		},
+		{
+			name:    "forwarded",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"},
+			status:  200, sev: logging.Info, remoteIP: "203.0.113.7",
+		},
+		{
+			name:    "forged forwarded",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.7, 10.0.0.1"},
+			status:  200, sev: logging.Info, remoteIP: "203.0.113.7",
+		},
+		{
+			name:    "one hop",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6,203.0.113.7"},
+			status:  200, sev: logging.Info, remoteIP: "203.0.113.7", hops: 1,
+		},
+		{
+			name:    "more hops than entries",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1"},
+			status:  200, sev: logging.Info, remoteIP: "203.0.113.7", hops: 3,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, ForwardedHops: tc.hops})
+			r := httptest.NewRequest("POST", "/things?x=1", strings.NewReader("abc"))
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			lg.Middleware(tc.handler).ServeHTTP(httptest.NewRecorder(), r)
+			es := rec.Entries()
+			if len(es) != 1 {
+				t.Fatalf("got %d entries, want 1", len(es))
+			}
+			e := es[0]
+			if logtest.Severity(e) != tc.sev {
+				t.Errorf("severity = %v, want %v", e.Severity, tc.sev)
+			}
+			hr := e.HTTPRequest
+			if hr == nil {
+				t.Fatal("missing httpRequest")
+			}
+			if hr.Status != tc.status || hr.ResponseSize != tc.size || hr.RequestSize != 3 || hr.RemoteIP != tc.remoteIP {
+				t.Errorf("got %+v", hr)
+			}
+			if hr.RequestMethod != "POST" || hr.RequestURL != "http://example.com/things?x=1" {
+				t.Errorf("got %+v", hr)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequestURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		tls     bool
+		want    string
+	}{
+		{"http", "/a/b?x=1", nil, false, "http://example.com/a/b?x=1"},
+		{"tls", "/a", nil, true, "https://example.com/a"},
+		{"forwarded proto", "/a", map[string]string{"X-Forwarded-Proto": "https"}, false, "https://example.com/a"},
+		{"forwarded proto list", "/a", map[string]string{"X-Forwarded-Proto": "HTTPS, http"}, false, "https://example.com/a"},
+		{"forwarded proto wins", "/a", map[string]string{"X-Forwarded-Proto": "http"}, true, "http://example.com/a"},
+		{"escaped path", "/a%2Fb", nil, false, "http://example.com/a%2Fb"},
+		{"absolute target", "http://other.example/p", nil, false, "http://other.example/p"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+			var seen string
+			h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.URL.String()
+			}))
+			r := httptest.NewRequest("GET", tc.target, nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if seen != r.URL.String() {
+				t.Errorf("handler saw %q, want the request untouched", seen)
+			}
+			es := rec.Find(func(e gcputils.Entry) bool { return e.HTTPRequest != nil })
+			if len(es) != 1 {
+				t.Fatalf("got %d request logs", len(es))
+			}
+			if got := es[0].HTTPRequest.RequestURL; got != tc.want {
+				t.Errorf("url = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareSampling(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Sampling: &gcputils.Sampling{Initial: 1, Interval: time.Hour, ReportInterval: time.Hour},
+	})
+	defer lg.SetSampling(nil)
+	h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/busy", "/busy", "/busy", "/rare"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	// a busy route doesn't use up the samples of a rare one
+	var got []string
+	for _, e := range rec.Entries() {
+		got = append(got, e.HTTPRequest.RequestURL)
+	}
+	want := []string{"http://example.com/busy", "http://example.com/rare"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logged %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package gcputils
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Mode picks how log entries are written, regardless of platform
+type Mode int
+
+const (
+	// ModeAuto picks based on the platform: structured JSON where Google picks it up from stderr,
+	// the logging API on GCE and human readable console output everywhere else.
+	ModeAuto Mode = iota
+	// ModeConsole is human readable output, what you want when developing
+	ModeConsole
+	// ModeJSON writes the structured JSON that Cloud Run and friends ingest. Use this locally to see exactly
+	// what Cloud Logging will get.
+	ModeJSON
+	// ModeAPI sends entries with the logging API on any platform, eg: on-prem workers using a service account key.
+	// Requires InitLogging, until then it falls back to the console.
+	ModeAPI
+)
+
+// ModeEnvVar can be set to "json", "console" or "api" to override the mode, eg: GCPUTILS_LOG_MODE=json go run .
+const ModeEnvVar = "GCPUTILS_LOG_MODE"
+
+func (m Mode) String() string {
+	switch m {
+	case ModeConsole:
+		return "console"
+	case ModeJSON:
+		return "json"
+	case ModeAPI:
+		return "api"
+	}
+	return "auto"
+}
+
+// Set implements flag.Value so you can do: flag.Var(&opts.Mode, "log-mode", "auto, console, json or api")
+func (m *Mode) Set(s string) error {
+	m2, err := ParseMode(s)
+	if err != nil {
+		return err
+	}
+	*m = m2
+	return nil
+}
+
+// ParseMode parses auto, console, json or api
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "auto":
+		return ModeAuto, nil
+	case "console":
+		return ModeConsole, nil
+	case "json":
+		return ModeJSON, nil
+	case "api":
+		return ModeAPI, nil
+	}
+	return ModeAuto, fmt.Errorf("invalid log mode %q", s)
+}
+
+// SetMode overrides the mode, see Mode
+func SetMode(m Mode) {
+	std.SetMode(m)
+}
+
+// SetMode overrides the mode for this Logger
+func (lg *Logger) SetMode(m Mode) {
+	lg.update(func(o *Options) { o.Mode = m })
+}
+
+// SetOutput sets where structured JSON logs get written, eg: os.Stdout, a file or a bytes.Buffer in tests.
+func SetOutput(w io.Writer) {
+	std.SetOutput(w)
+}
+
+// SetOutput sets where this Logger writes structured JSON logs, still asynchronously if Options.Async was set
+func (lg *Logger) SetOutput(w io.Writer) {
+	lg.mu.Lock()
+	defer lg.mu.Unlock()
+	lg.cfg.Output = w
+	lg.setOutput(w, lg.cfg.Async)
+}
+
+// modeFromEnv is used when Options.Mode isn't set
+func modeFromEnv() Mode {
+	m, err := ParseMode(os.Getenv(ModeEnvVar))
+	if err != nil {
+		log.Printf("gcputils: %v, using auto", err)
+	}
+	return m
+}
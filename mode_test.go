@@ -0,0 +1,71 @@
+package gcputils_test
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/treeder/gcputils"
+)
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    gcputils.Mode
+		wantErr bool
+	}{
+		{"", gcputils.ModeAuto, false},
+		{"auto", gcputils.ModeAuto, false},
+		{"console", gcputils.ModeConsole, false},
+		{"json", gcputils.ModeJSON, false},
+		{"api", gcputils.ModeAPI, false},
+		{"JSON", gcputils.ModeJSON, false},
+		{"Console", gcputils.ModeConsole, false},
+		{" api\n", gcputils.ModeAPI, false},
+		{"text", gcputils.ModeAuto, true},
+		{"json,api", gcputils.ModeAuto, true},
+	}
+	for _, tc := range tests {
+		got, err := gcputils.ParseMode(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseMode(%q) error = %v, want error %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseMode(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+		if err == nil {
+			// String gives back something ParseMode understands
+			if again, _ := gcputils.ParseMode(got.String()); again != got {
+				t.Errorf("ParseMode(%q) = %v, want %v", got.String(), again, got)
+			}
+		}
+	}
+}
+
+func TestModeSet(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    gcputils.Mode
+		wantErr bool
+	}{
+		{nil, gcputils.ModeConsole, false},
+		{[]string{"-log-mode", "json"}, gcputils.ModeJSON, false},
+		{[]string{"-log-mode=API"}, gcputils.ModeAPI, false},
+		{[]string{"-log-mode", "nope"}, gcputils.ModeConsole, true},
+	}
+	for _, tc := range tests {
+		m := gcputils.ModeConsole
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Var(&m, "log-mode", "auto, console, json or api")
+		err := fs.Parse(tc.args)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%v: error = %v, want error %v", tc.args, err, tc.wantErr)
+		}
+		// a bad value leaves the mode alone
+		if m != tc.want {
+			t.Errorf("%v: mode = %v, want %v", tc.args, m, tc.want)
+		}
+	}
+}
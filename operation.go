@@ -0,0 +1,22 @@
+package gcputils
+
+import logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+
+// Operation groups log entries from a long running operation, see Line.Operation
+type Operation struct {
+	// ID is unique to the operation, eg: a job ID
+	ID string `json:"id,omitempty"`
+	// Producer identifies what's doing the work, eg: "github.com/me/myjob"
+	Producer string `json:"producer,omitempty"`
+	// First is set on the first entry of the operation
+	First bool `json:"first,omitempty"`
+	// Last is set on the last entry of the operation
+	Last bool `json:"last,omitempty"`
+}
+
+func (o *Operation) proto() *logpb.LogEntryOperation {
+	if o == nil {
+		return nil
+	}
+	return &logpb.LogEntryOperation{Id: o.ID, Producer: o.Producer, First: o.First, Last: o.Last}
+}
@@ -0,0 +1,71 @@
+package gcputils_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/treeder/gcputils"
+)
+
+func TestOperationJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeJSON, Output: out, DisableSourceLocation: true})
+	l := lg.Info()
+	l.Operation("job-1", "myjob", true, false).Println("start")
+	l.Operation("job-1", "myjob", false, false).Println("working")
+	l.Operation("job-1", "myjob", false, true).Println("done")
+	l.Println("plain")
+	want := []string{
+		`"logging.googleapis.com/operation":{"id":"job-1","producer":"myjob","first":true}`,
+		`"logging.googleapis.com/operation":{"id":"job-1","producer":"myjob"}`,
+		`"logging.googleapis.com/operation":{"id":"job-1","producer":"myjob","last":true}`,
+		"",
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %s", len(lines), len(want), out)
+	}
+	for i, w := range want {
+		if w == "" {
+			if strings.Contains(lines[i], "operation") {
+				t.Errorf("line %d: didn't expect an operation in %s", i, lines[i])
+			}
+			continue
+		}
+		if !strings.Contains(lines[i], w) {
+			t.Errorf("line %d: want %s in %s", i, w, lines[i])
+		}
+	}
+}
+
+func TestOperationAPI(t *testing.T) {
+	srv, opts := newFakeLogging(t)
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeAPI, ProjectID: "proj"})
+	ctx := context.Background()
+	if err := lg.InitLogging(ctx, opts, gcputils.DelayThreshold(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	lg.Info().Operation("job-1", "myjob", true, false).Println("start")
+	lg.Info().Operation("job-1", "myjob", false, true).Println("done")
+	lg.Info().Println("plain")
+	if err := lg.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	es := srv.entries()
+	if len(es) != 3 {
+		t.Fatalf("got %d entries, want 3", len(es))
+	}
+	first, last := es[0].Operation, es[1].Operation
+	if first.GetId() != "job-1" || first.GetProducer() != "myjob" || !first.GetFirst() || first.GetLast() {
+		t.Errorf("first entry operation = %v", first)
+	}
+	if last.GetId() != "job-1" || last.GetProducer() != "myjob" || last.GetFirst() || !last.GetLast() {
+		t.Errorf("last entry operation = %v", last)
+	}
+	if es[2].Operation != nil {
+		t.Errorf("didn't expect an operation, got %v", es[2].Operation)
+	}
+}
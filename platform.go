@@ -0,0 +1,123 @@
+package gcputils
+
+import (
+	"os"
+
+	"cloud.google.com/go/compute/metadata"
+)
+
+// Platform is the Google Cloud environment (or lack thereof) we're running in.
+type Platform int
+
+const (
+	// PlatformUnknown means the platform hasn't been detected yet
+	PlatformUnknown Platform = iota
+	// PlatformLocal is anywhere that isn't Google Cloud, like your laptop
+	PlatformLocal
+	// PlatformGCE is a plain Compute Engine instance
+	PlatformGCE
+	// PlatformCloudRun is a Cloud Run service
+	PlatformCloudRun
+	// PlatformCloudRunJob is a Cloud Run job
+	PlatformCloudRunJob
+	// PlatformCloudFunctions is Cloud Functions (both gen1 and gen2)
+	PlatformCloudFunctions
+	// PlatformGKE is a container running on Kubernetes Engine
+	PlatformGKE
+	// PlatformAppEngine is App Engine standard or flexible
+	PlatformAppEngine
+)
+
+func (p Platform) String() string {
+	switch p {
+	case PlatformLocal:
+		return "local"
+	case PlatformGCE:
+		return "gce"
+	case PlatformCloudRun:
+		return "cloudrun"
+	case PlatformCloudRunJob:
+		return "cloudrunjob"
+	case PlatformCloudFunctions:
+		return "cloudfunctions"
+	case PlatformGKE:
+		return "gke"
+	case PlatformAppEngine:
+		return "appengine"
+	}
+	return "unknown"
+}
+
+// OnGCP returns true if the platform is any of the Google Cloud ones
+func (p Platform) OnGCP() bool {
+	return p != PlatformUnknown && p != PlatformLocal
+}
+
+// structured returns true if the platform picks up structured JSON logs written to stdout/stderr.
+// GCE doesn't, so we have to use the logging API there.
+func (p Platform) structured() bool {
+	switch p {
+	case PlatformCloudRun, PlatformCloudRunJob, PlatformCloudFunctions, PlatformGKE, PlatformAppEngine:
+		return true
+	}
+	return false
+}
+
+// Detector figures out what Platform we're running on
+type Detector func() Platform
+
+// DetectPlatform is the default Detector. It checks the env vars that each platform sets,
+// then falls back to the metadata server.
+func DetectPlatform() Platform {
+	return detect(os.Getenv, metadata.OnGCE, metadata.InstanceName)
+}
+
+// EnvDetector returns a Detector that only looks at env vars using getenv, never the metadata server.
+// Handy for tests: gcputils.SetDetector(gcputils.EnvDetector(func(k string) string { return fakeEnv[k] }))
+func EnvDetector(getenv func(string) string) Detector {
+	return func() Platform {
+		return detect(getenv, func() bool { return false }, nil)
+	}
+}
+
+func detect(getenv func(string) string, onGCE func() bool, instanceName func() (string, error)) Platform {
+	// order matters here, gen2 functions and jobs are built on Cloud Run and may set K_SERVICE too
+	switch {
+	case getenv("CLOUD_RUN_JOB") != "":
+		return PlatformCloudRunJob
+	case getenv("FUNCTION_TARGET") != "":
+		return PlatformCloudFunctions
+	case getenv("K_SERVICE") != "":
+		return PlatformCloudRun
+	case getenv("GAE_ENV") != "" || getenv("GAE_SERVICE") != "":
+		return PlatformAppEngine
+	case getenv("KUBERNETES_SERVICE_HOST") != "":
+		return PlatformGKE
+	}
+	if onGCE() {
+		// From what I can see, instanceName is empty if on cloud run (instanceID used to be empty)
+		if instanceName != nil {
+			s, _ := instanceName()
+			if s == "" {
+				return PlatformCloudRun
+			}
+		}
+		return PlatformGCE
+	}
+	return PlatformLocal
+}
+
+// SetDetector replaces the Detector used to figure out the platform, it will run on the next log call.
+func SetDetector(d Detector) {
+	std.update(func(o *Options) { o.Detector = d })
+}
+
+// SetPlatform forces the platform, skipping detection. Useful for tests or running a particular mode locally.
+func SetPlatform(p Platform) {
+	std.update(func(o *Options) { o.Platform = p })
+}
+
+// CurrentPlatform returns the platform we're logging for, detecting it if that hasn't happened yet
+func CurrentPlatform() Platform {
+	return std.Platform()
+}
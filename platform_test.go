@@ -0,0 +1,56 @@
+package gcputils_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestEnvDetector(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want gcputils.Platform
+	}{
+		{map[string]string{}, gcputils.PlatformLocal},
+		{map[string]string{"K_SERVICE": "api"}, gcputils.PlatformCloudRun},
+		{map[string]string{"K_SERVICE": "api", "CLOUD_RUN_JOB": "job"}, gcputils.PlatformCloudRunJob},
+		{map[string]string{"K_SERVICE": "fn", "FUNCTION_TARGET": "Handle"}, gcputils.PlatformCloudFunctions},
+		{map[string]string{"GAE_ENV": "standard"}, gcputils.PlatformAppEngine},
+		{map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"}, gcputils.PlatformGKE},
+	}
+	for _, tc := range tests {
+		d := gcputils.EnvDetector(func(k string) string { return tc.env[k] })
+		if got := d(); got != tc.want {
+			t.Errorf("%v: got %v, want %v", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestSetPlatformWhileLogging(t *testing.T) {
+	rec := logtest.Configure(gcputils.Options{Platform: gcputils.PlatformLocal})
+	defer gcputils.Configure(gcputils.Options{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				gcputils.Info().Println("hi")
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if j%2 == 0 {
+			gcputils.SetPlatform(gcputils.PlatformCloudRun)
+		} else {
+			gcputils.SetPlatform(gcputils.PlatformLocal)
+		}
+		gcputils.SetMode(gcputils.ModeJSON)
+	}
+	wg.Wait()
+	if rec.Len() != 400 {
+		t.Errorf("got %d entries, want 400", rec.Len())
+	}
+}
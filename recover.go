@@ -0,0 +1,83 @@
+package gcputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+
+	"cloud.google.com/go/logging"
+)
+
+// Recoverer is middleware that recovers panics in next and logs them at CRITICAL with the request trace, formatted
+// so Error Reporting groups them. It responds with a 500 unless repanic is true, in which case the panic continues
+// after it's logged, or the handler already started writing the response.
+func Recoverer(next http.Handler, repanic bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// net/http uses this to abort a response, it's not an error
+				panic(v)
+			}
+			l := lineFromContext(r.Context())
+			if l.trace == "" {
+				l = l.WithTrace(r).(*line)
+			}
+			logPanic(r.Context(), l, v)
+			if repanic {
+				panic(v)
+			}
+			if rw.status != 0 {
+				// too late to change the status, the client gets a cut off response
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Recover logs a panic at CRITICAL and stops it. Defer it at the top of your goroutines:
+//
+//	go func() {
+//		defer gcputils.Recover(ctx)
+//		...
+//	}()
+func Recover(ctx context.Context) {
+	if v := recover(); v != nil {
+		logPanic(ctx, lineFromContext(ctx), v)
+	}
+}
+
+// RecoverAndPanic is like Recover, but panics again after logging
+func RecoverAndPanic(ctx context.Context) {
+	if v := recover(); v != nil {
+		logPanic(ctx, lineFromContext(ctx), v)
+		panic(v)
+	}
+}
+
+func lineFromContext(ctx context.Context) *line {
+	if l, ok := FromContext(ctx).(*line); ok {
+		return l
+	}
+	return &line{}
+}
+
+// logPanic formats the message like an unrecovered panic: "panic: v", blank line, then the goroutine stack.
+// Error Reporting parses that to group them.
+func logPanic(ctx context.Context, l *line, v interface{}) {
+	buf := make([]byte, 64<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	l = l.withSev(logging.Critical)
+	if !l.enabled(ctx) {
+		return
+	}
+	l = l.withCaller()
+	print3(ctx, l, fmt.Sprintf("panic: %v\n", v), string(buf), "")
+}
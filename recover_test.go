@@ -0,0 +1,110 @@
+package gcputils_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestRecoverer(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	h := lg.Middleware(gcputils.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), false))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 {
+		t.Errorf("code = %d, want 500", w.Code)
+	}
+	es := rec.Find(func(e gcputils.Entry) bool { return logtest.Severity(e) == logging.Critical })
+	if len(es) != 1 || !strings.HasPrefix(es[0].Message, "panic: boom\n") || !strings.Contains(es[0].Message, "goroutine ") {
+		t.Errorf("got %+v", rec.Entries())
+	}
+}
+
+func TestRecovererAfterWrite(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	h := lg.Middleware(gcputils.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	}), false))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || w.Body.String() != "partial" {
+		t.Errorf("got %d %q, want the partial 200 response left alone", w.Code, w.Body.String())
+	}
+	if !rec.HasEntry(logging.Critical, "panic: boom") {
+		t.Errorf("expected the panic to be logged, got %+v", rec.Entries())
+	}
+}
+
+func TestRecovererRepanic(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	tests := []struct {
+		name    string
+		v       interface{}
+		repanic bool
+		logged  bool
+	}{
+		{"repanic", "boom", true, true},
+		{"abort handler", http.ErrAbortHandler, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec.Reset()
+			h := lg.Middleware(gcputils.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic(tc.v)
+			}), tc.repanic))
+			func() {
+				defer func() {
+					if v := recover(); v != tc.v {
+						t.Errorf("recovered %v, want %v", v, tc.v)
+					}
+				}()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			}()
+			if got := rec.HasEntry(logging.Critical, "panic: "); got != tc.logged {
+				t.Errorf("logged = %v, want %v: %+v", got, tc.logged, rec.Entries())
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	ctx := gcputils.NewContext(context.Background(), lg.Info().With("job", "sync"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer gcputils.Recover(ctx)
+		panic("boom")
+	}()
+	<-done
+	es := rec.Find(func(e gcputils.Entry) bool { return logtest.Severity(e) == logging.Critical })
+	if len(es) != 1 || !strings.HasPrefix(es[0].Message, "panic: boom\n") || es[0].Fields["job"] != "sync" {
+		t.Errorf("got %+v", rec.Entries())
+	}
+}
+
+func TestRecoverAndPanic(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	ctx := gcputils.NewContext(context.Background(), lg.Info())
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Errorf("recovered %v, want boom", v)
+		}
+		if !rec.HasEntry(logging.Critical, "panic: boom") {
+			t.Errorf("expected the panic to be logged, got %+v", rec.Entries())
+		}
+	}()
+	func() {
+		defer gcputils.RecoverAndPanic(ctx)
+		panic("boom")
+	}()
+}
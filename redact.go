@@ -0,0 +1,380 @@
+package gcputils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/logging"
+)
+
+const redactedText = "[REDACTED]"
+
+// Redaction hides sensitive data before it gets logged, in every output: JSON, the logging API and the console.
+// It applies to the message and stack, fields (including nested maps like slog groups, http.Header, url.Values, slices
+// and structs), labels and the request URL and referer of request logs.
+type Redaction struct {
+	// Keys are matched case insensitively against field and label names. Any key containing one of these has its
+	// whole value replaced, eg: "token" catches "access_token" and "X-Auth-Token".
+	Keys []string
+	// Values are replaced wherever they match in string values and the message
+	Values []*regexp.Regexp
+	// Replacement defaults to [REDACTED]
+	Replacement string
+}
+
+var (
+	// CreditCardPattern matches the common card number formats, with or without spaces or dashes.
+	// Redaction only replaces matches that pass the Luhn check, so order numbers and the like are left alone.
+	CreditCardPattern = regexp.MustCompile(`\b(?:4\d{3}|5[1-5]\d{2}|2[2-7]\d{2}|3[47]\d{2}|6(?:011|5\d{2}))[ -]?\d{4,6}[ -]?\d{4,5}(?:[ -]?\d{1,4})?\b`)
+	// EmailPattern matches email addresses
+	EmailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	// PrivateKeyPattern matches PEM private keys, like the private_key in a service account JSON file
+	PrivateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+// DefaultRedaction covers the usual suspects: passwords, tokens and auth headers, credit cards, emails and private keys.
+// Append to Keys and Values if you need more.
+func DefaultRedaction() *Redaction {
+	return &Redaction{
+		Keys: []string{
+			"password", "passwd", "secret", "token", "authorization", "cookie", "api_key", "apikey", "private_key",
+		},
+		Values: []*regexp.Regexp{CreditCardPattern, EmailPattern, PrivateKeyPattern},
+	}
+}
+
+// Redacted wraps a value so it never gets logged, whether or not redaction is turned on:
+//
+//	gcputils.F("card", gcputils.Redacted{Value: card}).Println("charging")
+type Redacted struct {
+	Value interface{}
+}
+
+func (Redacted) String() string   { return redactedText }
+func (Redacted) GoString() string { return redactedText }
+
+// Format so %v, %+v, %#v etc. don't print Value either
+func (Redacted) Format(f fmt.State, verb rune) { io.WriteString(f, redactedText) }
+
+func (Redacted) MarshalJSON() ([]byte, error) { return []byte(`"` + redactedText + `"`), nil }
+
+// LogValue for slog
+func (Redacted) LogValue() slog.Value { return slog.StringValue(redactedText) }
+
+type redactor struct {
+	keys        []string
+	values      []*regexp.Regexp
+	replacement string
+}
+
+// SetRedaction turns on redaction, pass nil to turn it off. See DefaultRedaction for a good start.
+func SetRedaction(r *Redaction) {
+	std.SetRedaction(r)
+}
+
+// SetRedaction turns on redaction for this Logger, pass nil to turn it off
+func (lg *Logger) SetRedaction(r *Redaction) {
+	if r == nil {
+		lg.redaction.Store(nil)
+		return
+	}
+	rd := &redactor{
+		keys:        make([]string, len(r.Keys)),
+		values:      r.Values,
+		replacement: r.Replacement,
+	}
+	for i, k := range r.Keys {
+		rd.keys[i] = strings.ToLower(k)
+	}
+	if rd.replacement == "" {
+		rd.replacement = redactedText
+	}
+	lg.redaction.Store(rd)
+}
+
+// redact returns a copy of l with everything sensitive replaced, along with the message.
+// Never modifies l since its fields may be shared.
+func (r *redactor) redact(l *line, message string) (*line, string) {
+	l2 := *l
+	if l.fields != nil {
+		l2.fields = r.fields(l.fields, 0)
+	}
+	if l.labels != nil {
+		l2.labels = make(map[string]string, len(l.labels))
+		for k, v := range l.labels {
+			if r.sensitiveKey(k) {
+				v = r.replacement
+			} else {
+				v = r.string(v)
+			}
+			l2.labels[k] = v
+		}
+	}
+	if l.httpRequest != nil && l.httpRequest.Request != nil {
+		l2.httpRequest = r.httpRequest(l.httpRequest)
+	}
+	return &l2, r.string(message)
+}
+
+// httpRequest redacts the URL and referer on a copy, the handler may still be using the request
+func (r *redactor) httpRequest(hr *logging.HTTPRequest) *logging.HTTPRequest {
+	req := *hr.Request
+	req.URL = r.url(hr.Request.URL)
+	if ref := req.Header.Get("Referer"); ref != "" {
+		if ref2 := r.urlString(ref); ref2 != ref {
+			req.Header = req.Header.Clone()
+			req.Header.Set("Referer", ref2)
+		}
+	}
+	hr2 := *hr
+	hr2.Request = &req
+	return &hr2
+}
+
+func (r *redactor) urlString(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return r.string(s)
+	}
+	return r.url(u).String()
+}
+
+// url redacts the password, the path and the query values, checking query keys like field names.
+// Returns u if there's nothing to hide.
+func (r *redactor) url(u *url.URL) *url.URL {
+	if u == nil {
+		return nil
+	}
+	u2 := *u
+	changed := false
+	if _, ok := u.User.Password(); ok {
+		u2.User = url.UserPassword(u.User.Username(), r.replacement)
+		changed = true
+	}
+	if p := r.string(u.Path); p != u.Path {
+		u2.Path, u2.RawPath = p, ""
+		changed = true
+	}
+	if q := r.query(u.RawQuery); q != u.RawQuery {
+		u2.RawQuery = q
+		changed = true
+	}
+	if !changed {
+		return u
+	}
+	return &u2
+}
+
+// query redacts a raw query string in place, keeping the order and anything it can't parse
+func (r *redactor) query(q string) string {
+	if q == "" {
+		return q
+	}
+	var b strings.Builder
+	for i, part := range strings.Split(q, "&") {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			b.WriteString(part)
+			continue
+		}
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			key = k
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		if r.sensitiveKey(key) {
+			b.WriteString(r.replacement)
+			continue
+		}
+		val, err := url.QueryUnescape(v)
+		if err != nil {
+			val = v
+		}
+		if rv := r.string(val); rv != val {
+			// escape everything but the replacement so it stays readable
+			v = strings.ReplaceAll(url.QueryEscape(rv), url.QueryEscape(r.replacement), r.replacement)
+		}
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+func (r *redactor) sensitiveKey(k string) bool {
+	if len(r.keys) == 0 {
+		return false
+	}
+	k = strings.ToLower(k)
+	for _, s := range r.keys {
+		if strings.Contains(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) string(s string) string {
+	for _, re := range r.values {
+		if re == CreditCardPattern {
+			s = re.ReplaceAllStringFunc(s, func(m string) string {
+				if luhn(m) {
+					return r.replacement
+				}
+				return m
+			})
+			continue
+		}
+		s = re.ReplaceAllLiteralString(s, r.replacement)
+	}
+	return s
+}
+
+// luhn reports whether the digits in s have a valid Luhn check digit, skipping spaces and dashes
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+func (r *redactor) fields(fields map[string]interface{}, depth int) map[string]interface{} {
+	if depth >= maxDepth {
+		// probably a cycle, the encoder deals with it
+		return fields
+	}
+	m := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if r.sensitiveKey(k) {
+			m[k] = r.replacement
+			continue
+		}
+		m[k] = r.value(v, depth)
+	}
+	return m
+}
+
+// value redacts the types we can look inside of, see generic for the rest
+func (r *redactor) value(v interface{}, depth int) interface{} {
+	switch x := v.(type) {
+	case Redacted:
+		return r.replacement
+	case string:
+		return r.string(x)
+	case []byte:
+		return r.string(string(x))
+	case error:
+		// keep the error, and its chain, unless there's something to hide
+		s := x.Error()
+		if rs := r.string(s); rs != s {
+			return rs
+		}
+		return v
+	case map[string]interface{}:
+		return r.fields(x, depth+1)
+	case map[string]string:
+		m := make(map[string]string, len(x))
+		for k, s := range x {
+			if r.sensitiveKey(k) {
+				s = r.replacement
+			} else {
+				s = r.string(s)
+			}
+			m[k] = s
+		}
+		return m
+	case http.Header:
+		return r.header(x)
+	case url.Values:
+		return url.Values(r.header(x))
+	case map[string][]string:
+		return map[string][]string(r.header(x))
+	case []string:
+		s := make([]string, len(x))
+		for i := range x {
+			s[i] = r.string(x[i])
+		}
+		return s
+	case []interface{}:
+		if depth >= maxDepth {
+			return v
+		}
+		s := make([]interface{}, len(x))
+		for i := range x {
+			s[i] = r.value(x[i], depth+1)
+		}
+		return s
+	}
+	return r.generic(v, depth)
+}
+
+// generic redacts structs and other maps and slices by round tripping them through JSON, which is how they'd be
+// logged anyways. Returns v if there's nothing to hide, or it can't be marshaled (the encoder deals with that).
+func (r *redactor) generic(v interface{}, depth int) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return v
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var g interface{}
+	if err := d.Decode(&g); err != nil {
+		return v
+	}
+	g2 := r.value(g, depth)
+	if reflect.DeepEqual(g, g2) {
+		return v
+	}
+	return g2
+}
+
+func (r *redactor) header(h map[string][]string) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, vs := range h {
+		s := make([]string, len(vs))
+		for i := range vs {
+			if r.sensitiveKey(k) {
+				s[i] = r.replacement
+			} else {
+				s[i] = r.string(vs[i])
+			}
+		}
+		h2[k] = s
+	}
+	return h2
+}
@@ -0,0 +1,169 @@
+package gcputils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Sampling drops repetitive log lines so hot paths don't flood your logs (and your bill).
+// Lines are grouped by key, which is the format for Printf, the first argument for Print/Println if it's a string,
+// or whatever you set with Line.Sample(key). Within each Interval, the first Initial lines for a key are logged,
+// then every Thereafter-th line after that. ERROR and above are never sampled.
+type Sampling struct {
+	Initial int
+	// Thereafter, 0 drops everything after Initial
+	Thereafter int
+	// Interval defaults to 1 second
+	Interval time.Duration
+	// ReportInterval is how often a line with counts of dropped entries gets logged, defaults to 1 minute
+	ReportInterval time.Duration
+}
+
+// maxSampleKeys caps the keys tracked per window, so Println("user " + id) can't grow the maps forever.
+// Past the cap, new keys share otherSampleKey.
+const maxSampleKeys = 1000
+
+const otherSampleKey = "_other"
+
+type sampler struct {
+	cfg Sampling
+	lg  *Logger // reports go through the Logger it samples for
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	dropped     map[string]int64
+	stop        chan struct{}
+}
+
+// SetSampling turns on sampling, pass nil to turn it off
+func SetSampling(s *Sampling) {
+	std.SetSampling(s)
+}
+
+// SetSampling turns on sampling for this Logger, pass nil to turn it off
+func (lg *Logger) SetSampling(s *Sampling) {
+	lg.swapSampling(s).close()
+}
+
+// swapSampling starts sampling with s and returns the sampler it replaced. Close that one without holding lg.mu,
+// its report is logged and logging may need to run setup.
+func (lg *Logger) swapSampling(s *Sampling) *sampler {
+	var sm *sampler
+	if s != nil {
+		sm = &sampler{
+			cfg:     *s,
+			lg:      lg,
+			counts:  map[string]int{},
+			dropped: map[string]int64{},
+			stop:    make(chan struct{}),
+		}
+		if sm.cfg.Interval <= 0 {
+			sm.cfg.Interval = time.Second
+		}
+		if sm.cfg.ReportInterval <= 0 {
+			sm.cfg.ReportInterval = time.Minute
+		}
+		go sm.reportLoop()
+	}
+	return lg.sampling.Swap(sm)
+}
+
+// close stops the report loop and logs whatever was dropped since the last report
+func (s *sampler) close() {
+	if s == nil {
+		return
+	}
+	close(s.stop)
+	s.report()
+}
+
+// sample returns false if the line should be dropped
+func (l *line) sample(template string) bool {
+	s := l.logger().sampling.Load()
+	if s == nil || l.sev >= logging.Error {
+		return true
+	}
+	if l.sampleKey != "" {
+		template = l.sampleKey
+	}
+	return s.allow(template)
+}
+
+func (s *sampler) allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.windowStart) >= s.cfg.Interval {
+		s.windowStart = now
+		clear(s.counts)
+	}
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= maxSampleKeys {
+		key = otherSampleKey
+	}
+	s.counts[key]++
+	n := s.counts[key]
+	if n <= s.cfg.Initial {
+		return true
+	}
+	if s.cfg.Thereafter > 0 && (n-s.cfg.Initial)%s.cfg.Thereafter == 0 {
+		return true
+	}
+	if _, ok := s.dropped[key]; !ok && len(s.dropped) >= maxSampleKeys {
+		key = otherSampleKey
+	}
+	s.dropped[key]++
+	return false
+}
+
+func (s *sampler) reportLoop() {
+	t := time.NewTicker(s.cfg.ReportInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.report()
+		}
+	}
+}
+
+// report logs the dropped counts since the last report
+func (s *sampler) report() {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = map[string]int64{}
+	s.mu.Unlock()
+	if len(dropped) == 0 {
+		return
+	}
+	var total int64
+	for _, n := range dropped {
+		total += n
+	}
+	// straight to print3, the report must not be sampled or filtered out itself
+	l := s.lg.line(logging.Warning)
+	l.fields = map[string]interface{}{"dropped": dropped}
+	print3(nil, l, fmt.Sprintf("sampling dropped %d log entries", total), "", "")
+}
+
+// Sample sets the key used for sampling, instead of the message template. See SetSampling.
+func (l *line) Sample(key string) Line {
+	l2 := l.clone()
+	l2.sampleKey = key
+	return l2
+}
+
+// template returns the sampling key for Print and Println, which is the first arg if it's a string
+func template(v []interface{}) string {
+	if len(v) > 0 {
+		if s, ok := v[0].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package gcputils_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestSamplerAllow(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  gcputils.Sampling
+		want []bool
+	}{
+		{"initial only", gcputils.Sampling{Initial: 2, Interval: time.Hour}, []bool{true, true, false, false, false}},
+		{"thereafter", gcputils.Sampling{Initial: 2, Thereafter: 3, Interval: time.Hour}, []bool{true, true, false, false, true, false, false, true}},
+		{"drop all", gcputils.Sampling{Interval: time.Hour}, []bool{false, false}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			allow := gcputils.SamplerAllow(tc.cfg)
+			for i, want := range tc.want {
+				if got := allow("k"); got != want {
+					t.Errorf("call %d: got %v, want %v", i+1, got, want)
+				}
+			}
+			// keys are counted separately
+			if tc.cfg.Initial > 0 && !allow("other") {
+				t.Error("first line for another key should be allowed")
+			}
+		})
+	}
+}
+
+func TestSamplerWindowResets(t *testing.T) {
+	allow := gcputils.SamplerAllow(gcputils.Sampling{Initial: 1, Interval: 20 * time.Millisecond})
+	if !allow("k") || allow("k") {
+		t.Fatal("expected first allowed and second dropped")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !allow("k") {
+		t.Error("expected the first line in a new window to be allowed")
+	}
+}
+
+func TestSamplingReport(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Sampling: &gcputils.Sampling{Initial: 0, ReportInterval: 50 * time.Millisecond},
+	})
+	defer lg.SetSampling(nil)
+	for i := 0; i < 5; i++ {
+		lg.Info().Println("x")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !rec.HasEntry(logging.Warning, "sampling dropped") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	es := rec.Find(func(e gcputils.Entry) bool { return logtest.Severity(e) == logging.Warning })
+	if len(es) != 1 {
+		t.Fatalf("got %d reports, want 1: %+v", len(es), rec.Entries())
+	}
+	if es[0].Message != "sampling dropped 5 log entries" {
+		t.Errorf("message = %q", es[0].Message)
+	}
+	if want := map[string]int64{"x": 5}; !reflect.DeepEqual(es[0].Fields["dropped"], want) {
+		t.Errorf("dropped = %v, want %v", es[0].Fields["dropped"], want)
+	}
+}
+
+func TestSamplingShutdownReports(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Sampling: &gcputils.Sampling{Initial: 0, ReportInterval: time.Hour},
+	})
+	for i := 0; i < gcputils.MaxSampleKeys+5; i++ {
+		lg.Info().Println(fmt.Sprintf("user %d", i))
+	}
+	if err := lg.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	es := rec.Find(func(e gcputils.Entry) bool { return logtest.Severity(e) == logging.Warning })
+	if len(es) != 1 {
+		t.Fatalf("got %d reports, want 1 on Shutdown: %+v", len(es), es)
+	}
+	if want := fmt.Sprintf("sampling dropped %d log entries", gcputils.MaxSampleKeys+5); es[0].Message != want {
+		t.Errorf("message = %q, want %q", es[0].Message, want)
+	}
+	dropped := es[0].Fields["dropped"].(map[string]int64)
+	if len(dropped) != gcputils.MaxSampleKeys+1 {
+		t.Errorf("got %d keys, want %d", len(dropped), gcputils.MaxSampleKeys+1)
+	}
+	if dropped["_other"] != 5 {
+		t.Errorf("_other = %d, want 5", dropped["_other"])
+	}
+	// sampling is off after Shutdown
+	lg.Info().Println("after")
+	if !rec.HasEntry(logging.Info, "after") {
+		t.Error("expected lines after Shutdown to be logged")
+	}
+}
+
+func TestSamplingReportOnReconfigure(t *testing.T) {
+	out := &syncBuffer{}
+	o := gcputils.Options{
+		Platform: gcputils.PlatformLocal,
+		Mode:     gcputils.ModeJSON,
+		Output:   out,
+		Sampling: &gcputils.Sampling{Initial: 0, ReportInterval: time.Hour},
+	}
+	lg := gcputils.New(o)
+	lg.Info().Println("x")
+	// the report on Configure has to resolve the state again
+	lg.SetMode(gcputils.ModeJSON)
+	done := make(chan struct{})
+	go func() {
+		gcputils.ConfigureLogger(lg, o)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Configure deadlocked reporting the old sampler")
+	}
+	lg.SetSampling(nil)
+	if n := out.lines(); n != 1 {
+		t.Errorf("got %d lines, want the 1 report", n)
+	}
+}
@@ -0,0 +1,179 @@
+package gcputils
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gotils/v2"
+)
+
+// Extra slog levels so slog users can reach the rest of the Cloud Logging severities.
+// slog.LevelDebug, LevelInfo, LevelWarn and LevelError map to DEBUG, INFO, WARNING and ERROR.
+const (
+	LevelNotice    = slog.Level(2)
+	LevelCritical  = slog.Level(12)
+	LevelAlert     = slog.Level(16)
+	LevelEmergency = slog.Level(20)
+)
+
+// SlogHandler is a slog.Handler that writes through the same pipeline as the rest of this package,
+// so you can mix slog and Line calls and get identical output.
+type SlogHandler struct {
+	lg     *Logger
+	level  slog.Leveler
+	fields map[string]interface{}
+	groups []string
+}
+
+// NewSlogHandler returns a slog.Handler, level is the minimum level to log and can be nil to log everything.
+//
+//	slog.SetDefault(slog.New(gcputils.NewSlogHandler(nil)))
+func NewSlogHandler(level slog.Leveler) *SlogHandler {
+	return std.SlogHandler(level)
+}
+
+// SlogHandler returns a slog.Handler that writes through this Logger, see NewSlogHandler
+func (lg *Logger) SlogHandler(level slog.Leveler) *SlogHandler {
+	return &SlogHandler{lg: lg, level: level}
+}
+
+// Enabled implements slog.Handler. Component levels are checked again in Handle since the record can
+// have its own component attr.
+func (h *SlogHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	if h.level != nil && l < h.level.Level() {
+		return false
+	}
+	return (&line{lg: h.lg, sev: slogSeverity(l), fields: h.fields}).enabled(ctx)
+}
+
+// Handle implements slog.Handler
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	l := &line{lg: h.lg, sev: slogSeverity(r.Level), fields: cloneFields(h.fields)}
+	stack := ""
+	r.Attrs(func(a slog.Attr) bool {
+		if err, ok := a.Value.Resolve().Any().(error); ok {
+			var stacked gotils.FullStacked
+			if errors.As(err, &stacked) && stack == "" {
+				l.stack = stacked.Stack()
+				stack = gotils.StackToString(l.stack)
+				for k, v := range stacked.Fields() {
+					if _, ok := l.fields[k]; !ok {
+						l.fields[k] = v
+					}
+				}
+			}
+		}
+		addAttr(groupFields(l.fields, h.groups), a)
+		return true
+	})
+	if !l.enabled(ctx) || !l.sample(r.Message) {
+		return nil
+	}
+	if stack == "" && l.sev >= logging.Error {
+		stack = gotils.StackToString(gotils.TakeStacktrace())
+	}
+	if len(l.fields) == 0 {
+		l.fields = nil
+	}
+	if !l.logger().setup().disableSource {
+		l.source = sourceFromPC(r.PC)
+	}
+	print3(ctx, l, r.Message, stack, "")
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.fields = cloneFields(h.fields)
+	m := groupFields(h2.fields, h.groups)
+	for _, a := range attrs {
+		addAttr(m, a)
+	}
+	return &h2
+}
+
+// WithGroup implements slog.Handler
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+	return &h2
+}
+
+func slogSeverity(l slog.Level) logging.Severity {
+	switch {
+	case l >= LevelEmergency:
+		return logging.Emergency
+	case l >= LevelAlert:
+		return logging.Alert
+	case l >= LevelCritical:
+		return logging.Critical
+	case l >= slog.LevelError:
+		return logging.Error
+	case l >= slog.LevelWarn:
+		return logging.Warning
+	case l >= LevelNotice:
+		return logging.Notice
+	case l >= slog.LevelInfo:
+		return logging.Info
+	}
+	return logging.Debug
+}
+
+// groupFields returns the map to add fields to for the current group, creating nested maps as needed
+func groupFields(fields map[string]interface{}, groups []string) map[string]interface{} {
+	m := fields
+	for _, g := range groups {
+		m2, ok := m[g].(map[string]interface{})
+		if !ok {
+			m2 = map[string]interface{}{}
+			m[g] = m2
+		}
+		m = m2
+	}
+	return m
+}
+
+func addAttr(m map[string]interface{}, a slog.Attr) {
+	v := a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if v.Kind() != slog.KindGroup {
+		m[a.Key] = v.Any()
+		return
+	}
+	attrs := v.Group()
+	if len(attrs) == 0 {
+		return
+	}
+	if a.Key == "" {
+		// inline the group
+		for _, a2 := range attrs {
+			addAttr(m, a2)
+		}
+		return
+	}
+	m2 := map[string]interface{}{}
+	for _, a2 := range attrs {
+		addAttr(m2, a2)
+	}
+	m[a.Key] = m2
+}
+
+// cloneFields deep copies nested maps (groups) so handlers don't share them
+func cloneFields(fields map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if m2, ok := v.(map[string]interface{}); ok {
+			v = cloneFields(m2)
+		}
+		m[k] = v
+	}
+	return m
+}
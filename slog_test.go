@@ -0,0 +1,63 @@
+package gcputils_test
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestSlogHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    func(l *slog.Logger)
+		sev    logging.Severity
+		fields map[string]interface{}
+	}{
+		{"info", func(l *slog.Logger) { l.Info("hi", "user", "bob") }, logging.Info, map[string]interface{}{"user": "bob"}},
+		{"warn", func(l *slog.Logger) { l.Warn("hi") }, logging.Warning, nil},
+		{"notice", func(l *slog.Logger) { l.Log(context.Background(), gcputils.LevelNotice, "hi") }, logging.Notice, nil},
+		{"critical", func(l *slog.Logger) { l.Log(context.Background(), gcputils.LevelCritical, "hi") }, logging.Critical, nil},
+		{
+			"group",
+			func(l *slog.Logger) { l.WithGroup("req").With("id", 1).Info("hi", slog.Group("user", "name", "bob")) },
+			logging.Info,
+			map[string]interface{}{"req": map[string]interface{}{"id": int64(1), "user": map[string]interface{}{"name": "bob"}}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+			tc.log(slog.New(lg.SlogHandler(nil)))
+			es := rec.Entries()
+			if len(es) != 1 {
+				t.Fatalf("got %d entries", len(es))
+			}
+			// errors and up have the stack after the message
+			if logtest.Severity(es[0]) != tc.sev || !strings.HasPrefix(es[0].Message, "hi") {
+				t.Errorf("got %v %q, want %v", es[0].Severity, es[0].Message, tc.sev)
+			}
+			if len(tc.fields) > 0 && !reflect.DeepEqual(es[0].Fields, tc.fields) {
+				t.Errorf("fields = %#v, want %#v", es[0].Fields, tc.fields)
+			}
+		})
+	}
+}
+
+func TestSlogHandlerLevels(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	lg.SetComponentLevel("db", logging.Error)
+	l := slog.New(lg.SlogHandler(slog.LevelInfo))
+	l.Debug("below handler level")
+	l.Info("kept")
+	l.Warn("below component level", "component", "db")
+	l.With("component", "db").Error("db error")
+	if rec.Len() != 2 || !rec.HasEntry(logging.Info, "kept") || !rec.HasEntry(logging.Error, "db error") {
+		t.Errorf("got %+v", rec.Entries())
+	}
+}
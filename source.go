@@ -0,0 +1,79 @@
+package gcputils
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	logpb "cloud.google.com/go/logging/apiv2/loggingpb"
+)
+
+// SourceLocation is where the log call came from, Logs Explorer links this to your code.
+type SourceLocation struct {
+	File     string `json:"file,omitempty"`
+	Line     int64  `json:"line,string,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// withCaller returns a copy with the source location set to the caller, unless the Logger has
+// Options.DisableSourceLocation set
+func (l *line) withCaller() *line {
+	if l.logger().setup().disableSource {
+		return l.withSource(nil)
+	}
+	return l.withSource(caller())
+}
+
+// caller returns the first frame outside of gcputils, gotils, slog, net/http (for Middleware and Transport)
+// and the runtime (for panics)
+func caller() *SourceLocation {
+	var pcs [16]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !shouldSkip(frame.Function) {
+			return sourceFromFrame(frame)
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+// sourceFromPC is for slog records which already have the caller
+func sourceFromPC(pc uintptr) *SourceLocation {
+	if pc == 0 {
+		return nil
+	}
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return sourceFromFrame(frame)
+}
+
+func sourceFromFrame(frame runtime.Frame) *SourceLocation {
+	if frame.File == "" {
+		return nil
+	}
+	return &SourceLocation{File: frame.File, Line: int64(frame.Line), Function: frame.Function}
+}
+
+func (s *SourceLocation) proto() *logpb.LogEntrySourceLocation {
+	if s == nil {
+		return nil
+	}
+	return &logpb.LogEntrySourceLocation{File: s.File, Line: s.Line, Function: s.Function}
+}
+
+// short is file:line for console output
+func (s *SourceLocation) short() string {
+	return filepath.Base(s.File) + ":" + strconv.FormatInt(s.Line, 10)
+}
+
+func shouldSkip(s string) bool {
+	return strings.HasPrefix(s, "github.com/treeder/gcputils.") ||
+		strings.HasPrefix(s, "github.com/treeder/gotils/") ||
+		strings.HasPrefix(s, "log/slog.") ||
+		strings.HasPrefix(s, "net/http.") ||
+		strings.HasPrefix(s, "runtime.")
+}
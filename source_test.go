@@ -0,0 +1,185 @@
+package gcputils_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+	"github.com/treeder/gotils/v2"
+)
+
+// here returns the file and line it's called from
+func here() (string, int) {
+	_, file, line, _ := runtime.Caller(1)
+	return file, line
+}
+
+// captureStdout returns what f prints to stdout, which is where console output goes
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-out
+}
+
+func TestSourceLocation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		// log returns the line it logged from
+		log func(lg *gcputils.Logger) int
+	}{
+		{"Println", func(lg *gcputils.Logger) int {
+			_, line := here()
+			lg.Println("hi")
+			return line + 1
+		}},
+		{"Line.Printf", func(lg *gcputils.Logger) int {
+			_, line := here()
+			lg.Info().F("k", 1).Printf("hi %d", 1)
+			return line + 1
+		}},
+		{"Err", func(lg *gcputils.Logger) int {
+			_, line := here()
+			lg.Err(errors.New("boom"))
+			return line + 1
+		}},
+		{"Errorf", func(lg *gcputils.Logger) int {
+			_, line := here()
+			lg.Errorf("boom %d", 1)
+			return line + 1
+		}},
+		{"Logf", func(lg *gcputils.Logger) int {
+			_, line := here()
+			lg.Info().Logf(ctx, "WARNING", "hi %d", 1)
+			return line + 1
+		}},
+		{"gotils", func(lg *gcputils.Logger) int {
+			gotils.SetLoggable(lg.Info())
+			defer gotils.SetLoggable(nil)
+			_, line := here()
+			gotils.L(ctx).Info().Println("hi")
+			return line + 1
+		}},
+		{"slog", func(lg *gcputils.Logger) int {
+			l := slog.New(lg.SlogHandler(nil))
+			_, line := here()
+			l.Info("hi", "k", 1)
+			return line + 1
+		}},
+		{"handler", func(lg *gcputils.Logger) int {
+			var line int
+			h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, line = here()
+				gcputils.FromContext(r.Context()).Println("hi")
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			return line + 1
+		}},
+	}
+	file, _ := here()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+			line := tc.log(lg)
+			es := rec.Entries()
+			if len(es) == 0 {
+				t.Fatal("nothing logged")
+			}
+			// the handler's line comes before the request log
+			s := es[0].SourceLocation
+			if s == nil {
+				t.Fatal("no source location")
+			}
+			if s.File != file || s.Line != int64(line) {
+				t.Errorf("got %s:%d, want %s:%d", s.File, s.Line, file, line)
+			}
+			if !strings.HasPrefix(s.Function, "github.com/treeder/gcputils_test.TestSourceLocation.") {
+				t.Errorf("function = %q", s.Function)
+			}
+		})
+	}
+}
+
+func TestSourceLocationMiddleware(t *testing.T) {
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal})
+	h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// the request log points at whoever called the middleware, not net/http or gcputils
+	file, line := here()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	es := rec.Find(func(e gcputils.Entry) bool { return e.HTTPRequest != nil })
+	if len(es) != 1 {
+		t.Fatalf("got %d request logs", len(es))
+	}
+	if s := es[0].SourceLocation; s == nil || s.File != file || s.Line != int64(line+1) {
+		t.Errorf("got %+v, want %s:%d", s, file, line+1)
+	}
+}
+
+func TestSourceLocationPackageFuncs(t *testing.T) {
+	rec := logtest.Configure(gcputils.Options{Platform: gcputils.PlatformLocal})
+	defer gcputils.Configure(gcputils.Options{})
+	file, line := here()
+	gcputils.Println("hi")
+	gcputils.Err(errors.New("boom"))
+	es := rec.Entries()
+	if len(es) != 2 {
+		t.Fatalf("got %d entries, want 2", len(es))
+	}
+	for i, e := range es {
+		if s := e.SourceLocation; s == nil || s.File != file || s.Line != int64(line+1+i) {
+			t.Errorf("entry %d: got %+v, want %s:%d", i, s, file, line+1+i)
+		}
+	}
+}
+
+func TestSourceLocationConsole(t *testing.T) {
+	lg := gcputils.New(gcputils.Options{Platform: gcputils.PlatformLocal, Mode: gcputils.ModeConsole})
+	var line int
+	out := captureStdout(t, func() {
+		_, line = here()
+		lg.Println("hi")
+	})
+	if want := "INFO\tsource_test.go:" + strconv.Itoa(line+1) + ": hi\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestDisableSourceLocation(t *testing.T) {
+	o := gcputils.Options{Platform: gcputils.PlatformLocal, DisableSourceLocation: true}
+	lg, rec := logtest.NewLogger(o)
+	lg.Println("hi")
+	slog.New(lg.SlogHandler(nil)).Info("hi")
+	for _, e := range rec.Entries() {
+		if e.SourceLocation != nil {
+			t.Errorf("got source location %+v", e.SourceLocation)
+		}
+	}
+	o.Mode = gcputils.ModeConsole
+	lg = gcputils.New(o)
+	if out := captureStdout(t, func() { lg.Println("hi") }); out != "INFO\thi\n" {
+		t.Errorf("console got %q", out)
+	}
+}
@@ -0,0 +1,121 @@
+package gcputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// W3C trace context header, see https://www.w3.org/TR/trace-context/
+	traceparentHeader = "traceparent"
+	// gotils context field keys for the span, the trace itself is stored under traceHeader
+	spanIDKey       = "logging.googleapis.com/spanId"
+	traceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+// traceInfo is the trace, span and sampled flag in the format Cloud Logging wants
+type traceInfo struct {
+	trace   string
+	spanID  string
+	sampled bool
+}
+
+// traceFromRequest checks for an OpenTelemetry span in the request context, then the traceparent header,
+// then X-Cloud-Trace-Context.
+func (lg *Logger) traceFromRequest(r *http.Request) traceInfo {
+	projectID := lg.setup().projectID
+	if projectID == "" { // should we log an error here since this won't work without it. "Must call InitLogging"
+		return traceInfo{}
+	}
+	if ti := lg.traceFromContext(r.Context()); ti.trace != "" {
+		return ti
+	}
+	traceID, spanID, sampled := parseTraceparent(r.Header.Get(traceparentHeader))
+	if traceID == "" {
+		traceID, spanID, sampled = parseCloudTraceContext(r.Header.Get(traceHeader))
+	}
+	if traceID == "" {
+		return traceInfo{}
+	}
+	return traceInfo{trace: tracePath(projectID, traceID), spanID: spanID, sampled: sampled}
+}
+
+// traceFromContext returns the active OpenTelemetry span, if there is one
+func (lg *Logger) traceFromContext(ctx context.Context) traceInfo {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return traceInfo{}
+	}
+	projectID := lg.setup().projectID
+	if projectID == "" {
+		return traceInfo{}
+	}
+	return traceInfo{trace: tracePath(projectID, sc.TraceID().String()), spanID: sc.SpanID().String(), sampled: sc.IsSampled()}
+}
+
+func tracePath(projectID, traceID string) string {
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, traceID)
+}
+
+// parseTraceparent parses version-traceid-spanid-flags, eg: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(h string) (traceID, spanID string, sampled bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	tid, err := trace.TraceIDFromHex(parts[1])
+	if err != nil {
+		return "", "", false
+	}
+	sid, err := trace.SpanIDFromHex(parts[2])
+	if err != nil {
+		return "", "", false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return "", "", false
+	}
+	return tid.String(), sid.String(), flags&1 == 1
+}
+
+// parseCloudTraceContext parses TRACE_ID/SPAN_ID;o=OPTIONS where SPAN_ID is decimal.
+// The span ID is returned as 16 hex chars since that's what Cloud Logging wants.
+func parseCloudTraceContext(h string) (traceID, spanID string, sampled bool) {
+	traceID, rest, _ := strings.Cut(h, "/")
+	if traceID == "" {
+		return "", "", false
+	}
+	span, opts, _ := strings.Cut(rest, ";")
+	if n, err := strconv.ParseUint(span, 10, 64); err == nil && n != 0 {
+		spanID = fmt.Sprintf("%016x", n)
+	}
+	return traceID, spanID, opts == "o=1"
+}
+
+// takeTrace moves the trace fields set by WithTrace(ctx, r) out of the fields and onto the line,
+// unless the line already has a trace.
+func (l *line) takeTrace() {
+	tr, ok := l.fields[traceHeader]
+	if !ok {
+		return
+	}
+	// copy since these could be the fields from a gotils error
+	fields := make(map[string]interface{}, len(l.fields))
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	delete(fields, traceHeader)
+	delete(fields, spanIDKey)
+	delete(fields, traceSampledKey)
+	if s, ok := tr.(string); ok && l.trace == "" && s != "" {
+		l.trace = s
+		l.spanID, _ = l.fields[spanIDKey].(string)
+		l.traceSampled, _ = l.fields[traceSampledKey].(bool)
+	}
+	l.fields = fields
+}
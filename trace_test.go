@@ -0,0 +1,140 @@
+package gcputils_test
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		in              string
+		traceID, spanID string
+		sampled         bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", false},
+		// future versions can add fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra", "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7", true},
+		{"", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "", "", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", "", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", "", "", false},
+	}
+	for _, tc := range tests {
+		traceID, spanID, sampled := gcputils.ParseTraceparent(tc.in)
+		if traceID != tc.traceID || spanID != tc.spanID || sampled != tc.sampled {
+			t.Errorf("%q: got %q %q %v, want %q %q %v", tc.in, traceID, spanID, sampled, tc.traceID, tc.spanID, tc.sampled)
+		}
+	}
+}
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		in              string
+		traceID, spanID string
+		sampled         bool
+	}{
+		{"105445aa7843bc8bf206b12000100000/1;o=1", "105445aa7843bc8bf206b12000100000", "0000000000000001", true},
+		{"105445aa7843bc8bf206b12000100000/18446744073709551615;o=0", "105445aa7843bc8bf206b12000100000", "ffffffffffffffff", false},
+		{"105445aa7843bc8bf206b12000100000/0", "105445aa7843bc8bf206b12000100000", "", false},
+		{"105445aa7843bc8bf206b12000100000", "105445aa7843bc8bf206b12000100000", "", false},
+		{"105445aa7843bc8bf206b12000100000/abc;o=1", "105445aa7843bc8bf206b12000100000", "", true},
+		{"", "", "", false},
+		{"/1;o=1", "", "", false},
+	}
+	for _, tc := range tests {
+		traceID, spanID, sampled := gcputils.ParseCloudTraceContext(tc.in)
+		if traceID != tc.traceID || spanID != tc.spanID || sampled != tc.sampled {
+			t.Errorf("%q: got %q %q %v, want %q %q %v", tc.in, traceID, spanID, sampled, tc.traceID, tc.spanID, tc.sampled)
+		}
+	}
+}
+
+func TestMiddlewareTrace(t *testing.T) {
+	tests := []struct {
+		name      string
+		projectID string
+		headers   map[string]string
+		// span is set as the request's OpenTelemetry span, if valid
+		span    trace.SpanContext
+		trace   string
+		spanID  string
+		sampled bool
+	}{
+		{
+			name:      "traceparent wins",
+			projectID: "p",
+			headers: map[string]string{
+				"traceparent":           "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=0",
+			},
+			trace: "projects/p/traces/4bf92f3577b34da6a3ce929d0e0e4736", spanID: "00f067aa0ba902b7", sampled: true,
+		},
+		{
+			name:      "cloud trace context",
+			projectID: "p",
+			headers:   map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"},
+			trace:     "projects/p/traces/105445aa7843bc8bf206b12000100000", spanID: "0000000000000001", sampled: true,
+		},
+		{
+			name:    "no project",
+			headers: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		},
+		{
+			name:      "sampled without a span",
+			projectID: "p",
+			headers:   map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/0;o=1"},
+			trace:     "projects/p/traces/105445aa7843bc8bf206b12000100000", sampled: true,
+		},
+		{
+			name:      "OpenTelemetry span wins",
+			projectID: "p",
+			headers:   map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+			span: trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+				SpanID:     trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+				TraceFlags: trace.FlagsSampled,
+			}),
+			trace: "projects/p/traces/0af7651916cd43dd8448eb211c80319c", spanID: "b7ad6b7169203331", sampled: true,
+		},
+		{name: "no headers", projectID: "p"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, ProjectID: tc.projectID})
+			h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx := r.Context()
+				gcputils.FromContext(ctx).Println("inside")
+				// these get the trace from the context
+				lg.Info().Logf(ctx, "INFO", "logf")
+				slog.New(lg.SlogHandler(nil)).InfoContext(ctx, "slog")
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.span.IsValid() {
+				r = r.WithContext(trace.ContextWithSpanContext(r.Context(), tc.span))
+			}
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			es := rec.Entries()
+			if len(es) != 4 {
+				t.Fatalf("got %d entries, want 4", len(es))
+			}
+			// the lines inside the handler and the request log are all correlated
+			for _, e := range es {
+				if e.Trace != tc.trace || e.SpanID != tc.spanID || e.TraceSampled != tc.sampled {
+					t.Errorf("%q: got %q %q %v, want %q %q %v", e.Message, e.Trace, e.SpanID, e.TraceSampled, tc.trace, tc.spanID, tc.sampled)
+				}
+			}
+		})
+	}
+}
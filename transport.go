@@ -0,0 +1,91 @@
+package gcputils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/treeder/gotils/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transport returns an http.RoundTripper that copies the trace captured by WithTrace (or Middleware) onto outgoing
+// requests as both X-Cloud-Trace-Context and traceparent with a new span ID, so traces continue into the services
+// you call. Requests that already have either header are left alone. Each request is logged at DEBUG with its
+// status, latency and URL (redacted if redaction is on). base defaults to http.DefaultTransport.
+//
+//	client := &http.Client{Transport: gcputils.Transport(nil)}
+//	req, _ := http.NewRequestWithContext(r.Context(), "GET", url, nil)
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	traceID, sampled := outboundTrace(ctx)
+	// if the caller set either header, leave both alone so there's only ever one trace
+	if traceID != "" && req.Header.Get(traceparentHeader) == "" && req.Header.Get(traceHeader) == "" {
+		// RoundTrippers must not modify the request
+		req = req.Clone(ctx)
+		span := newSpanID()
+		o := 0
+		if sampled {
+			o = 1
+		}
+		req.Header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-%02x", traceID, hex.EncodeToString(span[:]), o))
+		req.Header.Set(traceHeader, fmt.Sprintf("%s/%d;o=%d", traceID, binary.BigEndian.Uint64(span[:]), o))
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+	l := lineFromContext(ctx)
+	u := req.URL
+	// the url is a plain field, so redact it like the request URL of request logs
+	if r := l.logger().redaction.Load(); r != nil {
+		u = r.url(u)
+	}
+	l2 := l.With("url", u.String()).F("latency_ms", latency.Milliseconds())
+	if err != nil {
+		l2.Logf(ctx, "WARNING", "outbound %v %v failed after %v: %v", req.Method, req.URL.Host, latency, err)
+		return resp, err
+	}
+	l2.F("status", resp.StatusCode).Logf(ctx, "DEBUG", "outbound %v %v %v in %v", req.Method, req.URL.Host, resp.StatusCode, latency)
+	return resp, nil
+}
+
+// outboundTrace returns the raw 32 hex char trace ID from WithTrace or an OpenTelemetry span
+func outboundTrace(ctx context.Context) (string, bool) {
+	fields := gotils.Fields(ctx)
+	if tr, ok := fields[traceHeader].(string); ok && tr != "" {
+		sampled, _ := fields[traceSampledKey].(bool)
+		_, traceID, _ := strings.Cut(tr, "/traces/")
+		if len(traceID) == 32 {
+			return traceID, sampled
+		}
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if sc.IsValid() {
+		return sc.TraceID().String(), sc.IsSampled()
+	}
+	return "", false
+}
+
+func newSpanID() [8]byte {
+	var b [8]byte
+	for b == [8]byte{} {
+		rand.Read(b[:])
+	}
+	return b
+}
@@ -0,0 +1,106 @@
+package gcputils_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+	"github.com/treeder/gcputils"
+	"github.com/treeder/gcputils/logtest"
+)
+
+func TestTransport(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		incoming    string
+		outgoing    map[string]string // set on the outbound request already
+		wantParent  string            // prefix
+		wantCloud   string            // prefix
+		wantSampled string
+	}{
+		{"sampled", "00-" + traceID + "-00f067aa0ba902b7-01", nil, "00-" + traceID + "-", traceID + "/", "-01"},
+		{"not sampled", "00-" + traceID + "-00f067aa0ba902b7-00", nil, "00-" + traceID + "-", traceID + "/", "-00"},
+		// one trace only, the other header isn't added with a different trace ID
+		{
+			"keeps existing traceparent", "00-" + traceID + "-00f067aa0ba902b7-01",
+			map[string]string{"traceparent": "00-11111111111111111111111111111111-2222222222222222-01"},
+			"00-11111111111111111111111111111111-2222222222222222-01", "", "-01",
+		},
+		{
+			"keeps existing cloud trace", "00-" + traceID + "-00f067aa0ba902b7-01",
+			map[string]string{"X-Cloud-Trace-Context": "11111111111111111111111111111111/5;o=1"},
+			"", "11111111111111111111111111111111/5;o=1", "",
+		},
+		{"no trace", "", nil, "", "", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer srv.Close()
+
+			lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, ProjectID: "p"})
+			client := &http.Client{Transport: gcputils.Transport(nil)}
+			h := lg.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req, _ := http.NewRequestWithContext(r.Context(), "GET", srv.URL, nil)
+				for k, v := range tc.outgoing {
+					req.Header.Set(k, v)
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}))
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.incoming != "" {
+				r.Header.Set("traceparent", tc.incoming)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			tp, ctc := got.Get("traceparent"), got.Get("X-Cloud-Trace-Context")
+			if !strings.HasPrefix(tp, tc.wantParent) || !strings.HasSuffix(tp, tc.wantSampled) || (tp == "") != (tc.wantParent == "") {
+				t.Errorf("traceparent = %q, want %q...%q", tp, tc.wantParent, tc.wantSampled)
+			}
+			if !strings.HasPrefix(ctc, tc.wantCloud) || (ctc == "") != (tc.wantCloud == "") {
+				t.Errorf("X-Cloud-Trace-Context = %q, want prefix %q", ctc, tc.wantCloud)
+			}
+			// a new span for the outbound call
+			if tc.incoming != "" && tc.outgoing == nil && strings.Contains(tp, "00f067aa0ba902b7") {
+				t.Errorf("expected a new span ID, got %q", tp)
+			}
+			if !rec.HasEntry(logging.Debug, "outbound GET") {
+				t.Errorf("expected the outbound request to be logged, got %+v", rec.Entries())
+			}
+		})
+	}
+}
+
+func TestTransportRedactsURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	lg, rec := logtest.NewLogger(gcputils.Options{Platform: gcputils.PlatformLocal, Redact: gcputils.DefaultRedaction()})
+	ctx := gcputils.NewContext(context.Background(), lg.Info())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/cb?access_token=SECRET123&page=2", nil)
+	resp, err := (&http.Client{Transport: gcputils.Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := srv.URL + "/cb?access_token=[REDACTED]&page=2"
+	if !rec.HasField("url", want) {
+		t.Errorf("expected url %q, got %+v", want, rec.Entries())
+	}
+	for _, e := range rec.Entries() {
+		if strings.Contains(e.String(), "SECRET123") {
+			t.Errorf("token leaked: %s", e.String())
+		}
+	}
+}